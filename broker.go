@@ -0,0 +1,338 @@
+package lrdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wzshiming/resp"
+)
+
+// Message is a message published to a channel.
+type Message struct {
+	Pattern string // The pattern that matched the channel, empty for a channel subscription
+	Channel string
+	Payload []byte
+	Command string // The line of a command fed to the monitors, it's set instead of the channel
+}
+
+// Reply returns the reply that is pushed to a subscribed connection.
+func (m *Message) Reply() resp.Reply {
+	if m.Command != "" {
+		return resp.ReplyStatus(m.Command)
+	}
+	if m.Pattern != "" {
+		return Push{resp.ReplyMultiBulk{
+			resp.ReplyBulk("pmessage"),
+			resp.ReplyBulk(m.Pattern),
+			resp.ReplyBulk(m.Channel),
+			resp.ReplyBulk(m.Payload),
+		}}
+	}
+	return Push{resp.ReplyMultiBulk{
+		resp.ReplyBulk("message"),
+		resp.ReplyBulk(m.Channel),
+		resp.ReplyBulk(m.Payload),
+	}}
+}
+
+// Subscriber receives the messages of the channels and patterns it subscribes to.
+// The messages are delivered without blocking the publisher, when the buffer of the subscriber is full,
+// its channel of messages is closed after the buffered ones, so a message is never lost silently,
+// like the client output buffer limit of Redis.
+type Subscriber struct {
+	mu       sync.Mutex
+	c        chan *Message
+	closed   bool
+	overflow bool
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	monitor  bool
+}
+
+// NewSubscriber returns a subscriber that buffers up to size messages.
+func NewSubscriber(size int) *Subscriber {
+	return &Subscriber{
+		c:        make(chan *Message, size),
+		channels: map[string]struct{}{},
+		patterns: map[string]struct{}{},
+	}
+}
+
+// C returns the channel of messages, it's closed when the subscriber is closed by the broker.
+func (s *Subscriber) C() <-chan *Message {
+	return s.c
+}
+
+// Count returns the number of channels and patterns subscribed.
+func (s *Subscriber) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.channels) + len(s.patterns)
+}
+
+// Overflowed returns if the channel of messages is closed because the buffer was full.
+func (s *Subscriber) Overflowed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.overflow
+}
+
+// Monitoring returns if the subscriber receives the commands fed to the monitors.
+func (s *Subscriber) Monitoring() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.monitor
+}
+
+func (s *Subscriber) send(m *Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.c <- m:
+		return true
+	default:
+		s.overflow = true
+		s.closed = true
+		close(s.c)
+		return false
+	}
+}
+
+// Broker delivers the messages published to channels to their subscribers.
+// The commands are fed to the monitors the same way.
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[string]map[*Subscriber]struct{}
+	monitors map[*Subscriber]struct{}
+	nmonitor int32 // The number of monitors, so Feed skips formatting without them
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		channels: map[string]map[*Subscriber]struct{}{},
+		patterns: map[string]map[*Subscriber]struct{}{},
+		monitors: map[*Subscriber]struct{}{},
+	}
+}
+
+// Monitor makes sub receive every command fed to the broker.
+func (b *Broker) Monitor(sub *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.monitor = true
+	b.monitors[sub] = struct{}{}
+	atomic.StoreInt32(&b.nmonitor, int32(len(b.monitors)))
+}
+
+// Unmonitor stops sub from receiving the commands.
+func (b *Broker) Unmonitor(sub *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.monitor = false
+	delete(b.monitors, sub)
+	atomic.StoreInt32(&b.nmonitor, int32(len(b.monitors)))
+}
+
+// Feed sends the command of the client at addr to the monitors, like MONITOR of Redis:
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+//
+// It's called by the commands after the checks of ACL, with the name in lowercase.
+// The arguments of SensitiveCommands are redacted. It never blocks,
+// a monitor whose buffer is full is closed like any subscriber.
+func (b *Broker) Feed(addr string, cmd resp.Reply) {
+	if atomic.LoadInt32(&b.nmonitor) == 0 {
+		return
+	}
+	args, ok := cmd.(resp.ReplyMultiBulk)
+	if !ok || len(args) == 0 {
+		return
+	}
+
+	now := time.Now()
+	buf := strings.Builder{}
+	buf.WriteString(strconv.FormatInt(now.Unix(), 10))
+	buf.WriteByte('.')
+	micros := strconv.Itoa(now.Nanosecond() / int(time.Microsecond))
+	buf.WriteString(strings.Repeat("0", 6-len(micros)))
+	buf.WriteString(micros)
+	buf.WriteString(" [0 ")
+	buf.WriteString(addr)
+	buf.WriteByte(']')
+	redacted := SensitiveCommands[commandName(cmd)]
+	for i, arg := range args {
+		if i == 1 && redacted {
+			buf.WriteString(" (redacted)")
+			break
+		}
+		var val []byte
+		if resp.ConvertFrom(arg, &val) != nil {
+			val = []byte(arg.Format(0))
+		}
+		buf.WriteByte(' ')
+		writeRepr(&buf, val)
+	}
+	msg := &Message{Command: buf.String()}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.monitors {
+		sub.send(msg)
+	}
+}
+
+// Subscribe subscribes sub to the channels,
+// it returns the number of channels and patterns subscribed after each one.
+func (b *Broker) Subscribe(sub *Subscriber, channels ...string) []int {
+	return b.subscribe(b.channels, sub, sub.channels, channels)
+}
+
+// PSubscribe subscribes sub to the glob patterns,
+// it returns the number of channels and patterns subscribed after each one.
+func (b *Broker) PSubscribe(sub *Subscriber, patterns ...string) []int {
+	return b.subscribe(b.patterns, sub, sub.patterns, patterns)
+}
+
+// Unsubscribe unsubscribes sub from the channels, or all of its channels if none is given,
+// it returns the channels and the number of channels and patterns subscribed after each one.
+func (b *Broker) Unsubscribe(sub *Subscriber, channels ...string) ([]string, []int) {
+	return b.unsubscribe(b.channels, sub, sub.channels, channels)
+}
+
+// PUnsubscribe unsubscribes sub from the patterns, or all of its patterns if none is given,
+// it returns the patterns and the number of channels and patterns subscribed after each one.
+func (b *Broker) PUnsubscribe(sub *Subscriber, patterns ...string) ([]string, []int) {
+	return b.unsubscribe(b.patterns, sub, sub.patterns, patterns)
+}
+
+// Close unsubscribes sub from everything, stops it monitoring and closes its channel of messages.
+func (b *Broker) Close(sub *Subscriber) {
+	b.Unsubscribe(sub)
+	b.PUnsubscribe(sub)
+	b.Unmonitor(sub)
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.c)
+	}
+}
+
+// Publish sends the payload to the subscribers of channel,
+// it returns the number of subscribers that received it.
+func (b *Broker) Publish(channel string, payload []byte) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := 0
+	for sub := range b.channels[channel] {
+		if sub.send(&Message{Channel: channel, Payload: payload}) {
+			n++
+		}
+	}
+	for pattern, subs := range b.patterns {
+		if !Match(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			if sub.send(&Message{Pattern: pattern, Channel: channel, Payload: payload}) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (b *Broker) subscribe(index map[string]map[*Subscriber]struct{}, sub *Subscriber, own map[string]struct{}, names []string) []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	counts := make([]int, 0, len(names))
+	for _, name := range names {
+		subs, ok := index[name]
+		if !ok {
+			subs = map[*Subscriber]struct{}{}
+			index[name] = subs
+		}
+		subs[sub] = struct{}{}
+		own[name] = struct{}{}
+		counts = append(counts, len(sub.channels)+len(sub.patterns))
+	}
+	return counts
+}
+
+func (b *Broker) unsubscribe(index map[string]map[*Subscriber]struct{}, sub *Subscriber, own map[string]struct{}, names []string) ([]string, []int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+	}
+	counts := make([]int, 0, len(names))
+	for _, name := range names {
+		if subs, ok := index[name]; ok {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(index, name)
+			}
+		}
+		delete(own, name)
+		counts = append(counts, len(sub.channels)+len(sub.patterns))
+	}
+	return names, counts
+}
+
+// SensitiveCommands are the commands whose arguments have passwords, they are redacted in MONITOR, SLOWLOG and the logs.
+var SensitiveCommands = map[string]bool{
+	"auth":   true,
+	"hello":  true,
+	"acl":    true,
+	"config": true,
+}
+
+// writeRepr writes val in double quotes, with the special characters escaped like Redis.
+func writeRepr(buf *strings.Builder, val []byte) {
+	buf.WriteByte('"')
+	for _, c := range val {
+		switch c {
+		case '\\', '"':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		default:
+			if c < ' ' || c > '~' {
+				fmt.Fprintf(buf, "\\x%02x", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
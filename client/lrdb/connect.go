@@ -0,0 +1,93 @@
+package lrdb
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/resp"
+)
+
+// Option is an option of dialing the server.
+type Option func(*options)
+
+type options struct {
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+}
+
+// WithTLS dials the server with TLS, config should have RootCAs for a server with a private CA,
+// and Certificates if the server requires client certificates.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// WithDialTimeout limits the time of connecting to the server.
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = d
+	}
+}
+
+// Connect It's a client connection.
+type Connect struct {
+	conn    net.Conn
+	decoder *resp.Decoder
+	encoder *resp.Encoder
+}
+
+// unixScheme is the prefix of the addresses of Unix sockets, like unix:///var/run/lrdb.sock.
+const unixScheme = "unix://"
+
+// NewConnect Create a new connect.
+// The address is host:port for TCP, or unix:// followed by the path for a Unix socket.
+func NewConnect(address string, opts ...Option) (*Connect, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	dialer := &net.Dialer{
+		Timeout: o.dialTimeout,
+	}
+	network := "tcp"
+	if strings.HasPrefix(address, unixScheme) {
+		network = "unix"
+		address = strings.TrimPrefix(address, unixScheme)
+	}
+	var conn net.Conn
+	var err error
+	if o.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, network, address, o.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Connect{
+		conn:    conn,
+		decoder: resp.NewDecoder(conn),
+		encoder: resp.NewEncoder(conn),
+	}, nil
+}
+
+func (c *Connect) Send(r resp.Reply) error {
+	return c.encoder.Encode(r)
+}
+
+func (c *Connect) Recv() (resp.Reply, error) {
+	return c.decoder.Decode()
+}
+
+func (c *Connect) Cmd(r resp.Reply) (resp.Reply, error) {
+	err := c.Send(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.Recv()
+}
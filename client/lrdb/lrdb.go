@@ -2,19 +2,19 @@ package lrdb
 
 import (
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/wzshiming/lrdb/reply"
 	"github.com/wzshiming/resp"
-	"github.com/wzshiming/resp/client"
 )
 
 type Client struct {
-	client.Connect
+	Connect
 }
 
-func NewClient(address string) (*Client, error) {
-	conn, err := client.NewConnect(address)
+func NewClient(address string, opts ...Option) (*Client, error) {
+	conn, err := NewConnect(address, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -25,6 +25,7 @@ func NewClient(address string) (*Client, error) {
 
 	_, err = c.Ping()
 	if err != nil {
+		c.conn.Close()
 		return nil, err
 	}
 
@@ -66,7 +67,19 @@ func (c *Client) Execute(in, out interface{}) (err error) {
 // Ask the server to close the connection.
 // The connection is closed as soon as all pending replies have been written to the client.
 func (c *Client) Close() error {
-	return c.Execute([]string{"quit"}, nil)
+	err := c.Execute([]string{"quit"}, nil)
+	c.conn.Close()
+	return err
+}
+
+// Auth Authenticates the connection as the default user.
+func (c *Client) Auth(password string) (err error) {
+	return c.Execute([]string{"auth", password}, nil)
+}
+
+// AuthUser Authenticates the connection as the user of ACL.
+func (c *Client) AuthUser(username, password string) (err error) {
+	return c.Execute([]string{"auth", username, password}, nil)
 }
 
 // Ping Returns PONG if no argument is provided.
@@ -109,6 +122,107 @@ func (c *Client) Info() (info *Info, err error) {
 	return info, c.Execute([]string{"info"}, &info)
 }
 
+// ClientID Returns the id of the connection.
+func (c *Client) ClientID() (id int64, err error) {
+	return id, c.Execute([]string{"client", "id"}, &id)
+}
+
+// ClientSetName Assigns a name to the connection, which is shown in ClientList.
+func (c *Client) ClientSetName(name string) (err error) {
+	return c.Execute([]string{"client", "setname", name}, nil)
+}
+
+// ClientGetName Returns the name of the connection, or empty if no name is assigned.
+func (c *Client) ClientGetName() (name string, err error) {
+	return name, c.Execute([]string{"client", "getname"}, &name)
+}
+
+// ClientList Returns the connected clients, a line for each client, like:
+// id=1 addr=127.0.0.1:52555 name= age=5 idle=0 cmd=client tot-net-in=64 tot-net-out=12
+func (c *Client) ClientList() (list string, err error) {
+	return list, c.Execute([]string{"client", "list"}, &list)
+}
+
+// ClientKill Closes the connections of the clients with the address addr,
+// returns the number of killed clients.
+func (c *Client) ClientKill(addr string) (n int, err error) {
+	return n, c.Execute([]string{"client", "kill", "addr", addr, "skipme", "no"}, &n)
+}
+
+// CommandCount Returns the number of the commands of the server.
+func (c *Client) CommandCount() (n int, err error) {
+	return n, c.Execute([]string{"command", "count"}, &n)
+}
+
+// CommandGetKeys Returns the keys in the arguments of a command, args start with the name.
+func (c *Client) CommandGetKeys(args ...string) (keys []string, err error) {
+	return keys, c.Execute(append([]string{"command", "getkeys"}, args...), &keys)
+}
+
+// SlowLogGet Returns up to n of the newest commands in the slow log, all of them if n is -1.
+func (c *Client) SlowLogGet(n int) (entries []*SlowLogEntry, err error) {
+	res, err := c.Command("slowlog", "get", strconv.Itoa(n))
+	if err != nil {
+		return nil, err
+	}
+	if re, ok := res.(resp.ReplyError); ok {
+		return nil, errors.New(string(re))
+	}
+	list, _ := res.(resp.ReplyMultiBulk)
+	for _, item := range list {
+		fields, ok := item.(resp.ReplyMultiBulk)
+		if !ok || len(fields) < 6 {
+			return nil, errors.New("Error invalid slow log entry")
+		}
+		entry := &SlowLogEntry{}
+		var unix, micros int64
+		for i, out := range []interface{}{&entry.ID, &unix, &micros, &entry.Args, &entry.Addr, &entry.Name} {
+			err = resp.ConvertFrom(fields[i], out)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entry.Time = time.Unix(unix, 0)
+		entry.Duration = time.Duration(micros) * time.Microsecond
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// SlowLogLen Returns the number of the commands in the slow log.
+func (c *Client) SlowLogLen() (n int, err error) {
+	return n, c.Execute([]string{"slowlog", "len"}, &n)
+}
+
+// SlowLogReset Removes all the commands in the slow log.
+func (c *Client) SlowLogReset() (err error) {
+	return c.Execute([]string{"slowlog", "reset"}, nil)
+}
+
+// ConfigGet Returns the parameters of the server matching the glob-style pattern.
+func (c *Client) ConfigGet(pattern string) (params map[string]string, err error) {
+	var list []string
+	err = c.Execute([]string{"config", "get", pattern}, &list)
+	if err != nil {
+		return nil, err
+	}
+	params = map[string]string{}
+	for i := 0; i+1 < len(list); i += 2 {
+		params[list[i]] = list[i+1]
+	}
+	return params, nil
+}
+
+// ConfigSet Sets a parameter of the server that can be changed at runtime.
+func (c *Client) ConfigSet(name, value string) (err error) {
+	return c.Execute([]string{"config", "set", name, value}, nil)
+}
+
+// ConfigRewrite Writes the current parameters of the server to its config file.
+func (c *Client) ConfigRewrite() (err error) {
+	return c.Execute([]string{"config", "rewrite"}, nil)
+}
+
 // Set key to hold the string value.
 // If key already holds a value, it is overwritten, regardless of its type.
 func (c *Client) Set(k, v string) (err error) {
@@ -233,3 +347,295 @@ func (c *Client) Scan(start, end string, limit int) (m map[string]string, err er
 func (c *Client) RScan(start, end string, limit int) (m map[string]string, err error) {
 	return m, c.Execute([]interface{}{"rscan", start, end, limit}, &m)
 }
+
+// Expire Set a timeout in seconds on key. After the timeout has expired, the key will automatically be deleted.
+// Returns false if key does not exist.
+func (c *Client) Expire(k string, sec int) (b bool, err error) {
+	return b, c.Execute([]interface{}{"expire", k, sec}, &b)
+}
+
+// TTL Returns the remaining time to live in seconds of a key that has a timeout.
+// Returns -2 if the key does not exist, or -1 if the key exists but has no associated expire.
+func (c *Client) TTL(k string) (d int, err error) {
+	return d, c.Execute([]string{"ttl", k}, &d)
+}
+
+// Persist Remove the existing timeout on key.
+// Returns false if key does not exist or does not have an associated timeout.
+func (c *Client) Persist(k string) (b bool, err error) {
+	return b, c.Execute([]string{"persist", k}, &b)
+}
+
+// HSet Sets field in the hash stored at key to value.
+// If key does not exist, a new key holding a hash is created.
+// Returns true if field is a new field in the hash.
+func (c *Client) HSet(k, f, v string) (b bool, err error) {
+	return b, c.Execute([]string{"hset", k, f, v}, &b)
+}
+
+// HGet Returns the value associated with field in the hash stored at key.
+func (c *Client) HGet(k, f string) (r string, err error) {
+	return r, c.Execute([]string{"hget", k, f}, &r)
+}
+
+// HMGet Returns the values associated with the specified fields in the hash stored at key.
+// For every field that does not exist in the hash, an empty string is returned.
+func (c *Client) HMGet(k string, f ...string) (r []string, err error) {
+	return r, c.Execute(append([]string{"hmget", k}, f...), &r)
+}
+
+// HGetAll Returns all fields and values of the hash stored at key.
+func (c *Client) HGetAll(k string) (m map[string]string, err error) {
+	return m, c.Execute([]string{"hgetall", k}, &m)
+}
+
+// HDel Removes the specified fields from the hash stored at key.
+// Specified fields that do not exist within this hash are ignored.
+func (c *Client) HDel(k string, f ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"hdel", k}, f...), &d)
+}
+
+// HLen Returns the number of fields contained in the hash stored at key.
+func (c *Client) HLen(k string) (d int, err error) {
+	return d, c.Execute([]string{"hlen", k}, &d)
+}
+
+// HScan field-value pairs of the hash stored at key with fields in range (field_start, field_end]. ("", ""] means no range limit.
+func (c *Client) HScan(k string, start, end string, limit int) (m map[string]string, err error) {
+	return m, c.Execute([]interface{}{"hscan", k, start, end, limit}, &m)
+}
+
+// LPush Insert all the specified values at the head of the list stored at key.
+// If key does not exist, it is created as empty list before performing the push operations.
+// Returns the length of the list after the push operations.
+func (c *Client) LPush(k string, v ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"lpush", k}, v...), &d)
+}
+
+// RPush Insert all the specified values at the tail of the list stored at key.
+// If key does not exist, it is created as empty list before performing the push operation.
+// Returns the length of the list after the push operations.
+func (c *Client) RPush(k string, v ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"rpush", k}, v...), &d)
+}
+
+// LPop Removes and returns the first element of the list stored at key.
+func (c *Client) LPop(k string) (r string, err error) {
+	return r, c.Execute([]string{"lpop", k}, &r)
+}
+
+// RPop Removes and returns the last element of the list stored at key.
+func (c *Client) RPop(k string) (r string, err error) {
+	return r, c.Execute([]string{"rpop", k}, &r)
+}
+
+// LLen Returns the length of the list stored at key.
+func (c *Client) LLen(k string) (d int, err error) {
+	return d, c.Execute([]string{"llen", k}, &d)
+}
+
+// LIndex Returns the element at index in the list stored at key.
+// Negative indices can be used to designate elements starting at the tail of the list.
+func (c *Client) LIndex(k string, index int) (r string, err error) {
+	return r, c.Execute([]interface{}{"lindex", k, index}, &r)
+}
+
+// LRange Returns the specified elements of the list stored at key.
+// The offsets start and stop are zero-based indexes and both inclusive,
+// negative offsets can be used to designate elements starting at the tail of the list.
+func (c *Client) LRange(k string, start, stop int) (r []string, err error) {
+	return r, c.Execute([]interface{}{"lrange", k, start, stop}, &r)
+}
+
+// LTrim Trim an existing list so that it will contain only the specified range of elements specified.
+func (c *Client) LTrim(k string, start, stop int) (err error) {
+	return c.Execute([]interface{}{"ltrim", k, start, stop}, nil)
+}
+
+// ZAdd Adds member with the specified score to the sorted set stored at key.
+// If member is already a member of the sorted set, the score is updated and the element reinserted at the right position.
+// Returns true if member is new.
+func (c *Client) ZAdd(k string, score float64, member string) (b bool, err error) {
+	return b, c.Execute([]interface{}{"zadd", k, formatFloat(score), member}, &b)
+}
+
+// ZIncrBy Increments the score of member in the sorted set stored at key by increment.
+// If member does not exist in the sorted set, it is added with increment as its score.
+// Returns the new score of member.
+func (c *Client) ZIncrBy(k string, incr float64, member string) (f float64, err error) {
+	var r string
+	err = c.Execute([]interface{}{"zincrby", k, formatFloat(incr), member}, &r)
+	if err != nil {
+		return 0, err
+	}
+	return parseFloat(r)
+}
+
+// ZRem Removes the specified members from the sorted set stored at key.
+// Non existing members are ignored.
+func (c *Client) ZRem(k string, member ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"zrem", k}, member...), &d)
+}
+
+// ZCard Returns the number of elements of the sorted set stored at key.
+func (c *Client) ZCard(k string) (d int, err error) {
+	return d, c.Execute([]string{"zcard", k}, &d)
+}
+
+// ZScore Returns the score of member in the sorted set at key.
+func (c *Client) ZScore(k string, member string) (f float64, err error) {
+	var r string
+	err = c.Execute([]string{"zscore", k, member}, &r)
+	if err != nil {
+		return 0, err
+	}
+	return parseFloat(r)
+}
+
+// ZRank Returns the rank of member in the sorted set stored at key, with the scores ordered from low to high.
+// The rank is 0-based, -1 is returned if member does not exist.
+func (c *Client) ZRank(k string, member string) (d int, err error) {
+	return c.zrank("zrank", k, member)
+}
+
+// ZRevRank Like ZRank, but with the scores ordered from high to low.
+func (c *Client) ZRevRank(k string, member string) (d int, err error) {
+	return c.zrank("zrevrank", k, member)
+}
+
+func (c *Client) zrank(cmd string, k string, member string) (d int, err error) {
+	var r string
+	err = c.Execute([]string{cmd, k, member}, &r)
+	if err != nil {
+		return 0, err
+	}
+	if r == "" {
+		return -1, nil
+	}
+	return strconv.Atoi(r)
+}
+
+// ZRange Returns the specified range of members in the sorted set stored at key, ordered from the lowest to the highest score.
+// The offsets start and stop are zero-based indexes and both inclusive,
+// negative offsets can be used to designate elements starting at the highest score.
+func (c *Client) ZRange(k string, start, stop int) (r []string, err error) {
+	return r, c.Execute([]interface{}{"zrange", k, start, stop}, &r)
+}
+
+// ZRevRange Like ZRange, but ordered from the highest to the lowest score.
+func (c *Client) ZRevRange(k string, start, stop int) (r []string, err error) {
+	return r, c.Execute([]interface{}{"zrevrange", k, start, stop}, &r)
+}
+
+// ZRangeByScore Returns at most limit members in the sorted set at key with a score between min and max,
+// ordered from the lowest to the highest score, after skipping offset members.
+// The min and max are given like "1", "(1" for exclusive, or "-inf" and "+inf", and a negative limit means no limit.
+func (c *Client) ZRangeByScore(k string, min, max string, offset, limit int) (r []string, err error) {
+	return r, c.Execute([]interface{}{"zrangebyscore", k, min, max, "limit", offset, limit}, &r)
+}
+
+// ZRevRangeByScore Like ZRangeByScore, but ordered from the highest to the lowest score.
+func (c *Client) ZRevRangeByScore(k string, max, min string, offset, limit int) (r []string, err error) {
+	return r, c.Execute([]interface{}{"zrevrangebyscore", k, max, min, "limit", offset, limit}, &r)
+}
+
+// SAdd Add the specified members to the set stored at key.
+// Specified members that are already a member of this set are ignored.
+// Returns the number of elements that were added to the set.
+func (c *Client) SAdd(k string, member ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"sadd", k}, member...), &d)
+}
+
+// SRem Remove the specified members from the set stored at key.
+// Returns the number of members that were removed from the set.
+func (c *Client) SRem(k string, member ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"srem", k}, member...), &d)
+}
+
+// SIsMember Returns if member is a member of the set stored at key.
+func (c *Client) SIsMember(k string, member string) (b bool, err error) {
+	return b, c.Execute([]string{"sismember", k, member}, &b)
+}
+
+// SCard Returns the number of elements of the set stored at key.
+func (c *Client) SCard(k string) (d int, err error) {
+	return d, c.Execute([]string{"scard", k}, &d)
+}
+
+// SMembers Returns all the members of the set value stored at key.
+func (c *Client) SMembers(k string) (r []string, err error) {
+	return r, c.Execute([]string{"smembers", k}, &r)
+}
+
+// SInter Returns the members of the set resulting from the intersection of all the given sets.
+func (c *Client) SInter(k ...string) (r []string, err error) {
+	return r, c.Execute(append([]string{"sinter"}, k...), &r)
+}
+
+// SUnion Returns the members of the set resulting from the union of all the given sets.
+func (c *Client) SUnion(k ...string) (r []string, err error) {
+	return r, c.Execute(append([]string{"sunion"}, k...), &r)
+}
+
+// SDiff Returns the members of the set resulting from the difference between the first set and all the successive sets.
+func (c *Client) SDiff(k ...string) (r []string, err error) {
+	return r, c.Execute(append([]string{"sdiff"}, k...), &r)
+}
+
+// SInterStore Like SInter, but instead of returning the resulting set, it is stored in destination.
+// Returns the number of elements in the resulting set.
+func (c *Client) SInterStore(dest string, k ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"sinterstore", dest}, k...), &d)
+}
+
+// SUnionStore Like SUnion, but instead of returning the resulting set, it is stored in destination.
+// Returns the number of elements in the resulting set.
+func (c *Client) SUnionStore(dest string, k ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"sunionstore", dest}, k...), &d)
+}
+
+// SDiffStore Like SDiff, but instead of returning the resulting set, it is stored in destination.
+// Returns the number of elements in the resulting set.
+func (c *Client) SDiffStore(dest string, k ...string) (d int, err error) {
+	return d, c.Execute(append([]string{"sdiffstore", dest}, k...), &d)
+}
+
+// Multi Marks the start of a transaction block. Subsequent commands will be queued for atomic execution using Exec.
+func (c *Client) Multi() (err error) {
+	return c.Execute([]string{"multi"}, nil)
+}
+
+// Exec Executes all previously queued commands in a transaction and returns their replies.
+// Returns nil if the execution was aborted because a watched key was modified.
+func (c *Client) Exec() (r []resp.Reply, err error) {
+	req, err := resp.ConvertTo([]string{"exec"})
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Cmd(req)
+	if err != nil {
+		return nil, err
+	}
+	switch t := res.(type) {
+	case resp.ReplyError:
+		return nil, errors.New(string(t))
+	case resp.ReplyMultiBulk:
+		return []resp.Reply(t), nil
+	}
+	return nil, nil
+}
+
+// Discard Flushes all previously queued commands in a transaction.
+func (c *Client) Discard() (err error) {
+	return c.Execute([]string{"discard"}, nil)
+}
+
+// Watch Marks the given keys to be watched for conditional execution of a transaction.
+func (c *Client) Watch(k ...string) (err error) {
+	return c.Execute(append([]string{"watch"}, k...), nil)
+}
+
+// Unwatch Flushes all the previously watched keys for a transaction.
+func (c *Client) Unwatch() (err error) {
+	return c.Execute([]string{"unwatch"}, nil)
+}
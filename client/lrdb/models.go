@@ -1,5 +1,9 @@
 package lrdb
 
+import (
+	"time"
+)
+
 type Info struct {
 	WriteDelayCount    int
 	WriteDelayDuration int
@@ -15,4 +19,20 @@ type Info struct {
 	LevelRead          []int
 	LevelWrite         []int
 	LevelDurations     []int
+
+	RateLimitClientReadRejected  int
+	RateLimitClientWriteRejected int
+	RateLimitGlobalReadRejected  int
+	RateLimitGlobalWriteRejected int
+	RateLimitClients             int
+}
+
+// SlowLogEntry is a command in the slow log.
+type SlowLogEntry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	Args     []string
+	Addr     string
+	Name     string
 }
@@ -0,0 +1,111 @@
+package lrdb
+
+import (
+	"errors"
+
+	"github.com/wzshiming/resp"
+)
+
+// Message is a message received from a subscribed channel.
+type Message struct {
+	Pattern string // The pattern that matched the channel, empty for a channel subscription
+	Channel string
+	Payload string
+}
+
+// Publish Posts a message to the given channel.
+// Returns the number of clients that received the message.
+func (c *Client) Publish(channel, message string) (d int, err error) {
+	return d, c.Execute([]string{"publish", channel, message}, &d)
+}
+
+// Subscribe Subscribes the client to the given channels, the messages are sent to the returned channel.
+// The client is dedicated to receiving messages until it's unsubscribed from all channels and patterns,
+// then the returned channel is closed, so it must be called once until then.
+func (c *Client) Subscribe(channels ...string) (<-chan *Message, error) {
+	return c.subscribe("subscribe", channels)
+}
+
+// PSubscribe Subscribes the client to the given glob patterns, like Subscribe.
+func (c *Client) PSubscribe(patterns ...string) (<-chan *Message, error) {
+	return c.subscribe("psubscribe", patterns)
+}
+
+// Unsubscribe Unsubscribes the client from the given channels, or from all of them if none is given.
+// It can be called while the client is receiving messages.
+func (c *Client) Unsubscribe(channels ...string) error {
+	return c.send(append([]string{"unsubscribe"}, channels...))
+}
+
+// PUnsubscribe Unsubscribes the client from the given patterns, or from all of them if none is given.
+// It can be called while the client is receiving messages.
+func (c *Client) PUnsubscribe(patterns ...string) error {
+	return c.send(append([]string{"punsubscribe"}, patterns...))
+}
+
+func (c *Client) send(cmd []string) error {
+	req, err := resp.ConvertTo(cmd)
+	if err != nil {
+		return err
+	}
+	return c.Send(req)
+}
+
+func (c *Client) subscribe(cmd string, names []string) (<-chan *Message, error) {
+	if len(names) == 0 {
+		return nil, errors.New("Error wrong number of arguments")
+	}
+	err := c.send(append([]string{cmd}, names...))
+	if err != nil {
+		return nil, err
+	}
+
+	// The confirmation of each one is read before returning.
+	for range names {
+		res, err := c.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if re, ok := res.(resp.ReplyError); ok {
+			return nil, errors.New(string(re))
+		}
+	}
+
+	ch := make(chan *Message, 64)
+	go c.receive(ch)
+	return ch, nil
+}
+
+func (c *Client) receive(ch chan *Message) {
+	defer close(ch)
+	for {
+		res, err := c.Recv()
+		if err != nil {
+			return
+		}
+		var fields []string
+		err = resp.ConvertFrom(res, &fields)
+		if err != nil || len(fields) < 3 {
+			continue
+		}
+		switch fields[0] {
+		case "message":
+			ch <- &Message{
+				Channel: fields[1],
+				Payload: fields[2],
+			}
+		case "pmessage":
+			if len(fields) == 4 {
+				ch <- &Message{
+					Pattern: fields[1],
+					Channel: fields[2],
+					Payload: fields[3],
+				}
+			}
+		case "unsubscribe", "punsubscribe":
+			if fields[2] == "0" {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package lrdb
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrMaxClients = errors.New("Error max number of clients reached")
+
+// Client is a connection registered on the server.
+type Client struct {
+	id       uint64
+	conn     net.Conn
+	registry *Registry
+	created  time.Time
+	bytesIn  int64
+	bytesOut int64
+
+	mu         sync.Mutex
+	name       string
+	lastCmd    string
+	lastActive time.Time
+}
+
+// ClientInfo is a snapshot of a client.
+type ClientInfo struct {
+	ID       uint64
+	Addr     string
+	Name     string
+	Age      time.Duration
+	Idle     time.Duration
+	LastCmd  string
+	BytesIn  int64
+	BytesOut int64
+}
+
+// ID returns the unique id of the client.
+func (c *Client) ID() uint64 {
+	return c.id
+}
+
+// Registry returns the registry the client is registered on.
+func (c *Client) Registry() *Registry {
+	return c.registry
+}
+
+// Name returns the name set by CLIENT SETNAME.
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// SetName sets the name of the client.
+func (c *Client) SetName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.name = name
+}
+
+// Touch records that the command name is received.
+func (c *Client) Touch(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCmd = name
+	c.lastActive = time.Now()
+}
+
+// Info returns a snapshot of the client.
+func (c *Client) Info() ClientInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	return ClientInfo{
+		ID:       c.id,
+		Addr:     c.conn.RemoteAddr().String(),
+		Name:     c.name,
+		Age:      now.Sub(c.created),
+		Idle:     now.Sub(c.lastActive),
+		LastCmd:  c.lastCmd,
+		BytesIn:  atomic.LoadInt64(&c.bytesIn),
+		BytesOut: atomic.LoadInt64(&c.bytesOut),
+	}
+}
+
+// Kill closes the connection of the client.
+func (c *Client) Kill() error {
+	return c.conn.Close()
+}
+
+// Conn returns the connection of the client, which counts the bytes read and written.
+func (c *Client) Conn() net.Conn {
+	return countConn{c.conn, c}
+}
+
+type countConn struct {
+	net.Conn
+	client *Client
+}
+
+func (c countConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.client.bytesIn, int64(n))
+	return n, err
+}
+
+func (c countConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.client.bytesOut, int64(n))
+	return n, err
+}
+
+// Registry is the connected clients of a server.
+type Registry struct {
+	mu       sync.Mutex
+	clients  map[uint64]*Client
+	lastID   uint64
+	max      int
+	rejected uint64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		clients: map[uint64]*Client{},
+	}
+}
+
+// SetMax sets the max number of clients, 0 means no limit.
+func (r *Registry) SetMax(max int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.max = max
+}
+
+// Max returns the max number of clients, 0 means no limit.
+func (r *Registry) Max() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.max
+}
+
+// Accepted returns the number of clients ever added.
+func (r *Registry) Accepted() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastID
+}
+
+// Rejected returns the number of clients rejected for ErrMaxClients.
+func (r *Registry) Rejected() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rejected
+}
+
+// Add registers conn, it returns ErrMaxClients if there are too many clients.
+func (r *Registry) Add(conn net.Conn) (*Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.max > 0 && len(r.clients) >= r.max {
+		r.rejected++
+		return nil, ErrMaxClients
+	}
+	r.lastID++
+	now := time.Now()
+	c := &Client{
+		id:         r.lastID,
+		conn:       conn,
+		registry:   r,
+		created:    now,
+		lastActive: now,
+	}
+	r.clients[c.id] = c
+	return c, nil
+}
+
+// Remove unregisters c.
+func (r *Registry) Remove(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.id)
+}
+
+// Len returns the number of clients.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.clients)
+}
+
+// List returns the clients ordered by id.
+func (r *Registry) List() []*Client {
+	r.mu.Lock()
+	list := make([]*Client, 0, len(r.clients))
+	for _, c := range r.clients {
+		list = append(list, c)
+	}
+	r.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].id < list[j].id
+	})
+	return list
+}
+
+// Get returns the client by id, or nil if it's not connected.
+func (r *Registry) Get(id uint64) *Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.clients[id]
+}
@@ -1,27 +1,372 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
 	"github.com/wzshiming/lrdb/engine/leveldb"
+	"github.com/wzshiming/lrdb/metrics"
 )
 
-var port = flag.String("p", ":10008", "Listen port")
+var port = flag.String("p", ":10008", "Listen port, empty to listen only on the Unix socket")
 var path = flag.String("d", "./data", "Data path")
+var notify = flag.String("notify", "", "Keyspace events to notify, like notify-keyspace-events of Redis, e.g. KEA")
+var shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "How long to wait for the connections to drain on shutdown")
+var requirePass = flag.String("requirepass", "", "Password of the default user")
+var aclFile = flag.String("aclfile", "", "ACL file of the users, ACL LOAD and ACL SAVE read and write it")
+var tlsCert = flag.String("tls-cert", "", "TLS certificate file, the listener is TLS if it's set, SIGHUP reloads it")
+var tlsKey = flag.String("tls-key", "", "TLS private key file")
+var tlsClientCA = flag.String("tls-client-ca", "", "TLS CA file to verify the client certificates with, client certificates are required if it's set")
+var tlsMinVersion = flag.String("tls-min-version", "1.2", "Minimum TLS version")
+var maxClients = flag.Int("maxclients", 10000, "Max number of connected clients, 0 means no limit")
+var idleTimeout = flag.Duration("timeout", 0, "Close the connection after a client is idle for the duration, 0 means never")
+var httpAddress = flag.String("http", "", "Listen address of the HTTP/JSON gateway, disabled if it's empty")
+var memcachedAddress = flag.String("memcached", "", "Listen address of the memcached text protocol, disabled if it's empty. It bypasses the authentication, ACL, rate limiting and MONITOR, so it's refused with -requirepass or -aclfile")
+var unixSocket = flag.String("unixsocket", "", "Unix socket path to listen on, in addition to the port")
+var rateLimitBy = flag.String("ratelimit-by", engine.RateLimitByAddr, "How the rate limits of each client tell clients apart, addr, user or name")
+var rateLimitRead = flag.Float64("ratelimit-read", 0, "Read commands per second of each client, 0 means no limit")
+var rateLimitWrite = flag.Float64("ratelimit-write", 0, "Write commands per second of each client, 0 means no limit")
+var rateLimitGlobalRead = flag.Float64("ratelimit-global-read", 0, "Read commands per second of all clients, 0 means no limit")
+var rateLimitGlobalWrite = flag.Float64("ratelimit-global-write", 0, "Write commands per second of all clients, 0 means no limit")
+var slowLogSlowerThan = flag.Duration("slowlog-log-slower-than", 10*time.Millisecond, "Log the commands slower than the duration in SLOWLOG, negative to disable it")
+var slowLogMaxLen = flag.Int("slowlog-max-len", 128, "Max number of the commands in SLOWLOG")
+var metricsAddress = flag.String("metrics", "", "Listen address of the Prometheus metrics on /metrics, disabled if it's empty")
+var logLevel = flag.String("loglevel", "info", "Log level, debug, info, warn or error, debug logs every connection and command")
+var logFormat = flag.String("logformat", lrdb.LogText, "Log format, text or json")
+var logFile = flag.String("logfile", "", "Log file, the logs are written to stdout if it's empty, SIGHUP reopens it")
+var unixSocketPerm = flag.String("unixsocketperm", "700", "File permissions of the Unix socket, in octal")
+
+var compression = flag.String("leveldb-compression", "snappy", "Compression of the LevelDB blocks, snappy or none")
+var blockCacheSize = flag.Int("leveldb-block-cache-size", opt.DefaultBlockCacheCapacity, "Size in bytes of the LevelDB block cache")
+var writeBufferSize = flag.Int("leveldb-write-buffer-size", opt.DefaultWriteBuffer, "Size in bytes of the LevelDB memtable, written to a table when it's full")
+var openFilesCache = flag.Int("leveldb-open-files-cache", opt.DefaultOpenFilesCacheCapacity, "Max number of the LevelDB tables kept open")
+var configFile = flag.String("config", "", "Config file with a parameter on each line like 'maxclients 100', the parameters are the flags without '-', "+
+	"the environment variables like LRDB_MAXCLIENTS override the file, and the command line overrides both")
+
+// debugLogger writes the entries only when the logger is at the debug level, it's for the log of every command,
+// which can be turned on at runtime by CONFIG SET loglevel debug.
+type debugLogger struct {
+	*lrdb.LevelLogger
+}
+
+func (l debugLogger) Enabled(level lrdb.Level) bool {
+	return l.LevelLogger.Enabled(lrdb.LevelDebug) && l.LevelLogger.Enabled(level)
+}
+
+func (l debugLogger) Log(level lrdb.Level, msg string, fields ...lrdb.Field) {
+	if l.Enabled(level) {
+		l.LevelLogger.Log(level, msg, fields...)
+	}
+}
+
+// nonNegative returns an error if the value of a parameter is negative.
+func nonNegative(v float64) error {
+	if v < 0 {
+		return fmt.Errorf("should not be negative")
+	}
+	return nil
+}
 
 func main() {
 	flag.Parse()
-	db, err := leveldb.NewLevelDB(*path)
+	config := lrdb.NewConfig(flag.CommandLine, *configFile, "LRDB_")
+	config.Hide("config")
+	err := config.Load()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	level, err := lrdb.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var output io.Writer = os.Stdout
+	var file *lrdb.LogFile
+	if *logFile != "" {
+		file, err = lrdb.OpenLogFile(*logFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer file.Close()
+		output = file
+	}
+	logger, err := lrdb.NewLevelLogger(output, level, *logFormat)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	options := &opt.Options{
+		BlockCacheCapacity:     *blockCacheSize,
+		WriteBuffer:            *writeBufferSize,
+		OpenFilesCacheCapacity: *openFilesCache,
+	}
+	switch *compression {
+	default:
+		fmt.Printf("Error invalid compression '%s'\n", *compression)
+		return
+	case "snappy":
+		options.Compression = opt.SnappyCompression
+	case "none":
+		options.Compression = opt.NoCompression
+	}
+	db, err := leveldb.NewLevelDBWithOptions(*path, options)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	commands := db.Cmd()
+	var metric *metrics.Metrics
+	if *metricsAddress != "" {
+		// Added first to be the outermost, so the recovered panics are counted as errors.
+		metric = metrics.NewMetrics()
+		metric.Instrument(commands)
+		metric.SetDBStats(db.DBStats)
+	}
+	commands.Use(engine.Recovery(logger))
+	commands.Use(engine.Logging(debugLogger{logger}, lrdb.LevelDebug))
+	commands.SetConfig(config)
+	if *requirePass != "" || *aclFile != "" {
+		acl := engine.NewACL(*requirePass)
+		if *aclFile != "" {
+			err := acl.LoadFile(*aclFile)
+			if err != nil {
+				fmt.Println(err)
+				db.Close()
+				return
+			}
+		}
+		commands.SetACL(acl)
+	}
+	// The rate limiter is set even without limits, so they can be set by CONFIG SET.
+	limiter, err := engine.NewRateLimiter(*rateLimitBy)
+	if err != nil {
+		fmt.Println(err)
+		db.Close()
+		return
+	}
+	commands.SetRateLimiter(limiter)
+
+	if *memcachedAddress != "" && (*requirePass != "" || *aclFile != "") {
+		fmt.Println("The memcached protocol has no authentication, -memcached can not be used with -requirepass or -aclfile")
+		db.Close()
+		return
+	}
+	if *port == "" && *unixSocket == "" && *httpAddress == "" && *memcachedAddress == "" {
+		fmt.Println("Nothing to listen on, set -p, -unixsocket, -http or -memcached")
+		db.Close()
+		return
+	}
+	perm, err := strconv.ParseUint(*unixSocketPerm, 8, 32)
 	if err != nil {
 		fmt.Println(err)
+		db.Close()
 		return
 	}
 
-	err = lrdb.NewLRDB(db.Cmd()).Listen(*port)
+	server := lrdb.NewLRDB(commands)
+	server.SetLogger(logger)
+	server.SetShutdownTimeout(*shutdownTimeout)
+	if metric != nil {
+		metric.SetServer(server)
+	}
+	notifier, err := lrdb.NewKeyspaceNotifier(server.Broker(), *notify)
 	if err != nil {
 		fmt.Println(err)
+		db.Close()
 		return
 	}
+	db.SetNotifier(notifier)
+
+	// The parameters that can be changed at runtime, the hooks apply the values after they are checked.
+	config.Hook("loglevel", func() error {
+		level, err := lrdb.ParseLevel(*logLevel)
+		if err != nil {
+			return err
+		}
+		logger.SetLevel(level)
+		return nil
+	})
+	config.Hook("maxclients", func() error {
+		err := nonNegative(float64(*maxClients))
+		if err != nil {
+			return err
+		}
+		server.SetMaxClients(*maxClients)
+		return nil
+	})
+	config.Hook("timeout", func() error {
+		err := nonNegative(float64(*idleTimeout))
+		if err != nil {
+			return err
+		}
+		server.SetIdleTimeout(*idleTimeout)
+		return nil
+	})
+	config.Hook("notify", func() error {
+		return notifier.SetFlags(*notify)
+	})
+	config.Hook("slowlog-log-slower-than", func() error {
+		commands.SlowLog().SetThreshold(*slowLogSlowerThan)
+		return nil
+	})
+	config.Hook("slowlog-max-len", func() error {
+		err := nonNegative(float64(*slowLogMaxLen))
+		if err != nil {
+			return err
+		}
+		commands.SlowLog().SetMaxLen(*slowLogMaxLen)
+		return nil
+	})
+	for _, l := range []struct {
+		name     string
+		rate     *float64
+		category string
+		set      func(category string, limit engine.RateLimit)
+	}{
+		{"ratelimit-read", rateLimitRead, engine.CategoryRead, limiter.SetClientLimit},
+		{"ratelimit-write", rateLimitWrite, engine.CategoryWrite, limiter.SetClientLimit},
+		{"ratelimit-global-read", rateLimitGlobalRead, engine.CategoryRead, limiter.SetGlobalLimit},
+		{"ratelimit-global-write", rateLimitGlobalWrite, engine.CategoryWrite, limiter.SetGlobalLimit},
+	} {
+		l := l
+		config.Hook(l.name, func() error {
+			err := nonNegative(*l.rate)
+			if err != nil {
+				return err
+			}
+			l.set(l.category, engine.RateLimit{Rate: *l.rate})
+			return nil
+		})
+	}
+	err = config.Apply()
+	if err != nil {
+		fmt.Println(err)
+		db.Close()
+		return
+	}
+
+	var loader *lrdb.TLSLoader
+	if *tlsCert != "" {
+		minVersion, err := lrdb.ParseTLSVersion(*tlsMinVersion)
+		if err != nil {
+			fmt.Println(err)
+			db.Close()
+			return
+		}
+		loader, err = lrdb.NewTLSLoader(lrdb.TLSOptions{
+			CertFile:     *tlsCert,
+			KeyFile:      *tlsKey,
+			ClientCAFile: *tlsClientCA,
+			MinVersion:   minVersion,
+		})
+		if err != nil {
+			fmt.Println(err)
+			db.Close()
+			return
+		}
+	}
+
+	// SIGHUP reloads the TLS certificates and reopens the log file.
+	if loader != nil || file != nil {
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, syscall.SIGHUP)
+			for range sig {
+				if loader != nil {
+					err := loader.Reload()
+					if err != nil {
+						logger.Log(lrdb.LevelError, "Reload TLS", lrdb.F("error", err))
+					}
+				}
+				if file != nil {
+					err := file.Reopen()
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		signal.Stop(sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil && err != lrdb.ErrServerClosed {
+			logger.Log(lrdb.LevelError, "Shutdown", lrdb.F("error", err))
+		}
+	}()
+
+	listens := []func() error{}
+	if *port != "" {
+		listens = append(listens, func() error {
+			if loader != nil {
+				return server.ListenTLS(*port, loader.Config())
+			}
+			return server.Listen(*port)
+		})
+	}
+	if *unixSocket != "" {
+		listens = append(listens, func() error {
+			return server.ListenUnix(*unixSocket, os.FileMode(perm))
+		})
+	}
+
+	if *httpAddress != "" {
+		listens = append(listens, func() error {
+			return server.ListenHTTP(*httpAddress)
+		})
+	}
+
+	if *metricsAddress != "" {
+		listens = append(listens, func() error {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metric)
+			return server.ListenHTTPHandler(*metricsAddress, mux)
+		})
+	}
+
+	if *memcachedAddress != "" {
+		listens = append(listens, func() error {
+			return server.ListenMemcached(*memcachedAddress, db.Memcached())
+		})
+	}
+
+	errs := make(chan error, len(listens))
+	for _, listen := range listens {
+		go func(listen func() error) {
+			errs <- listen()
+		}(listen)
+	}
+	for range listens {
+		err := <-errs
+		if err != nil && err != lrdb.ErrServerClosed {
+			// A listener failed, stop the others too.
+			logger.Log(lrdb.LevelError, "Listen", lrdb.F("error", err))
+			ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+			server.Shutdown(ctx)
+			cancel()
+		}
+	}
 }
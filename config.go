@@ -0,0 +1,295 @@
+package lrdb
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrConfigNoFile = errors.New("Error the server is running without a config file")
+
+// Config is the parameters of the server, which are the flags of a flag set.
+// They are set by the command line, the environment and the config file, in order of precedence,
+// and the ones with a hook can be changed at runtime by CONFIG SET.
+type Config struct {
+	mu     sync.Mutex
+	flags  *flag.FlagSet
+	file   string
+	prefix string
+	hidden map[string]bool
+	hooks  map[string]func() error
+}
+
+// NewConfig returns the config of the parameters in flags, which are loaded from file,
+// and from the environment variables named by prefix and the upper case names, e.g. LRDB_MAXCLIENTS.
+func NewConfig(flags *flag.FlagSet, file, prefix string) *Config {
+	return &Config{
+		flags:  flags,
+		file:   file,
+		prefix: prefix,
+		hidden: map[string]bool{},
+		hooks:  map[string]func() error{},
+	}
+}
+
+// File returns the config file, empty if there is none.
+func (c *Config) File() string {
+	return c.file
+}
+
+// Hide removes the flags that are not parameters, like the one of the config file itself.
+func (c *Config) Hide(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		c.hidden[name] = true
+	}
+}
+
+// Hook makes the parameter changeable at runtime, hook applies its value after it's set,
+// and an error rejects the value.
+func (c *Config) Hook(name string, hook func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks[name] = hook
+}
+
+// lookup returns the flag of the parameter, or nil if there is no such parameter.
+func (c *Config) lookup(name string) *flag.Flag {
+	if c.hidden[name] {
+		return nil
+	}
+	return c.flags.Lookup(name)
+}
+
+// Load sets the parameters from the config file and then the environment,
+// the ones set on the command line are kept.
+func (c *Config) Load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	set := map[string]bool{}
+	c.flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if c.file != "" {
+		f, err := os.Open(c.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			name, value, ok, err := parseConfigLine(scanner.Text())
+			if err != nil {
+				return fmt.Errorf("Error %s:%d: %s", c.file, n, err)
+			}
+			if !ok {
+				continue
+			}
+			if c.lookup(name) == nil {
+				return fmt.Errorf("Error %s:%d: unknown config parameter '%s'", c.file, n, name)
+			}
+			if set[name] {
+				continue
+			}
+			err = c.flags.Set(name, value)
+			if err != nil {
+				return fmt.Errorf("Error %s:%d: %s", c.file, n, err)
+			}
+		}
+		err = scanner.Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || c.hidden[f.Name] {
+			return
+		}
+		env := c.env(f.Name)
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return
+		}
+		e := c.flags.Set(f.Name, value)
+		if e != nil {
+			err = fmt.Errorf("Error environment %s: %s", env, e)
+		}
+	})
+	return err
+}
+
+// env returns the environment variable of the parameter.
+func (c *Config) env(name string) string {
+	return c.prefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Apply runs the hooks of all the parameters, to apply and check their values after they are loaded.
+func (c *Config) Apply() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.hooks))
+	for name := range c.hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := c.hooks[name]()
+		if err != nil {
+			return fmt.Errorf("Error config parameter '%s': %s", name, err)
+		}
+	}
+	return nil
+}
+
+// Get returns the names and the values of the parameters matching the glob-style pattern, sorted by name.
+func (c *Config) Get(pattern string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pattern = strings.ToLower(pattern)
+	list := []string{}
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if c.hidden[f.Name] || !Match(pattern, f.Name) {
+			return
+		}
+		list = append(list, f.Name, f.Value.String())
+	})
+	return list
+}
+
+// Set sets pairs of names and values of the parameters,
+// either all of them are set or none of them if any is invalid or can't be changed at runtime.
+func (c *Config) Set(pairs ...string) error {
+	if len(pairs)%2 != 0 {
+		return errors.New("Error the parameters should be pairs of names and values")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i != len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		if c.lookup(name) == nil {
+			return fmt.Errorf("Error unknown config parameter '%s'", pairs[i])
+		}
+		if c.hooks[name] == nil {
+			return fmt.Errorf("Error config parameter '%s' can't be changed at runtime", pairs[i])
+		}
+	}
+
+	olds := make([]string, 0, len(pairs))
+	for i := 0; i != len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		f := c.lookup(name)
+		olds = append(olds, name, f.Value.String())
+		err := f.Value.Set(pairs[i+1])
+		if err == nil {
+			err = c.hooks[name]()
+		}
+		if err != nil {
+			// Restore the ones already set, in reverse order in case a name is repeated.
+			for j := len(olds) - 2; j >= 0; j -= 2 {
+				c.flags.Set(olds[j], olds[j+1])
+				c.hooks[olds[j]]()
+			}
+			return fmt.Errorf("Error config parameter '%s': %s", pairs[i], err)
+		}
+	}
+	return nil
+}
+
+// Rewrite writes the current values to the config file, the lines of the parameters are updated in place,
+// the comments and the order are kept, and the parameters not in the file are appended if they are not default.
+func (c *Config) Rewrite() error {
+	if c.file == "" {
+		return ErrConfigNoFile
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var lines []string
+	mode := os.FileMode(0600)
+	data, err := ioutil.ReadFile(c.file)
+	if err == nil {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if fi, err := os.Stat(c.file); err == nil {
+			mode = fi.Mode().Perm()
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	written := map[string]bool{}
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		name, _, ok, err := parseConfigLine(line)
+		if err != nil || !ok {
+			out = append(out, line)
+			continue
+		}
+		f := c.lookup(name)
+		if f == nil {
+			out = append(out, line)
+			continue
+		}
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		out = append(out, formatConfigLine(name, f.Value.String()))
+	}
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if written[f.Name] || c.hidden[f.Name] {
+			return
+		}
+		value := f.Value.String()
+		if value == f.DefValue {
+			return
+		}
+		out = append(out, formatConfigLine(f.Name, value))
+	})
+
+	tmp := c.file + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(strings.Join(out, "\n")+"\n"), mode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// parseConfigLine parses a line like 'name value' of the config file, the value can be double quoted,
+// ok is false for empty lines and lines starting with '#'.
+func parseConfigLine(line string) (name, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", "", false, nil
+	}
+	i := strings.IndexAny(line, " \t")
+	if i == -1 {
+		return "", "", false, fmt.Errorf("should be like '%s <value>'", line)
+	}
+	name, value = strings.ToLower(line[:i]), strings.TrimSpace(line[i:])
+	if value != "" && value[0] == '"' {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", "", false, fmt.Errorf("invalid quoted value %s", value)
+		}
+		value = unquoted
+	}
+	return name, value, true, nil
+}
+
+func formatConfigLine(name, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\r\n\"#") {
+		value = strconv.Quote(value)
+	}
+	return name + " " + value
+}
@@ -0,0 +1,167 @@
+package lrdb
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/wzshiming/resp"
+)
+
+var (
+	ErrUnbalancedQuotes = errors.New("Error Protocol error: unbalanced quotes in request")
+	ErrInlineTooBig     = errors.New("Error Protocol error: too big inline request")
+)
+
+// maxInline is the max length of an inline command.
+const maxInline = 64 * 1024
+
+// Decoder decodes the commands of a connection.
+// Each command is either a RESP multi-bulk, or an inline command for clients like telnet and nc,
+// which is a line of arguments separated by spaces, and quoted with " or ' if they have spaces.
+type Decoder struct {
+	reader  *bufio.Reader
+	decoder *resp.Decoder
+}
+
+func NewDecoder(reader io.Reader) *Decoder {
+	bufread := bufio.NewReader(reader)
+	return &Decoder{
+		reader:  bufread,
+		decoder: resp.NewDecoder(bufread),
+	}
+}
+
+// Decode returns the next command.
+// ErrUnbalancedQuotes is returned for an inline command that can't be split,
+// the connection can go on with the next command after it.
+func (d *Decoder) Decode() (resp.Reply, error) {
+	for {
+		b, err := d.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == '*' {
+			return d.decoder.Decode()
+		}
+
+		line, err := d.readLine()
+		if err != nil {
+			return nil, err
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			return nil, err
+		}
+		// Empty lines are skipped, like the ones that telnet sends.
+		if len(args) != 0 {
+			return args, nil
+		}
+	}
+}
+
+func (d *Decoder) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		data, isPrefix, err := d.reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, data...)
+		if len(line) > maxInline {
+			return nil, ErrInlineTooBig
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// splitArgs splits an inline command like Redis,
+// in double quotes the escapes \n \r \t \b \a \xHH are supported, in single quotes only \' is.
+func splitArgs(line []byte) (resp.ReplyMultiBulk, error) {
+	args := resp.ReplyMultiBulk{}
+	i := 0
+	for {
+		for i != len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		arg := []byte{}
+		var quote byte
+		for done := false; !done; {
+			if i == len(line) {
+				if quote != 0 {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case quote == '"' && c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+				arg = append(arg, unhex(line[i+2])<<4|unhex(line[i+3]))
+				i += 4
+				continue
+			case quote == '"' && c == '\\' && i+1 < len(line):
+				i++
+				switch c = line[i]; c {
+				case 'n':
+					c = '\n'
+				case 'r':
+					c = '\r'
+				case 't':
+					c = '\t'
+				case 'b':
+					c = '\b'
+				case 'a':
+					c = '\a'
+				}
+				arg = append(arg, c)
+			case quote == '\'' && c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+				i++
+				arg = append(arg, '\'')
+			case quote != 0 && c == quote:
+				// The closing quote must be followed by a space or the end.
+				if i+1 < len(line) && !isSpace(line[i+1]) {
+					return nil, ErrUnbalancedQuotes
+				}
+				done = true
+			case quote != 0:
+				arg = append(arg, c)
+			case isSpace(c):
+				done = true
+			case c == '"' || c == '\'':
+				quote = c
+			default:
+				arg = append(arg, c)
+			}
+			i++
+		}
+		args = append(args, resp.ReplyBulk(arg))
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
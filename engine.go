@@ -4,8 +4,14 @@ import (
 	"github.com/wzshiming/resp"
 )
 
-type CmdFunc func(name string, args []resp.Reply) (resp.Reply, error)
+type CmdFunc func(s *Session, name string, args []resp.Reply) (resp.Reply, error)
 
 type Engine interface {
-	Cmd(resp.Reply) (resp.Reply, error)
+	Cmd(s *Session, r resp.Reply) (resp.Reply, error)
+}
+
+// Replies is the result of a command that replies more than once, like SUBSCRIBE,
+// each of them is written to the connection in order.
+type Replies struct {
+	resp.ReplyMultiBulk
 }
@@ -0,0 +1,676 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+var (
+	ErrNoAuth         = errors.New("NOAUTH Authentication required.")
+	ErrWrongPass      = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	ErrAuthNotEnabled = errors.New("Error AUTH called without any password configured")
+	ErrNoPermKeys     = errors.New("NOPERM this user has no permissions to access one of the keys used as arguments")
+	ErrNoPermChannels = errors.New("NOPERM this user has no permissions to access one of the channels used as arguments")
+	ErrACLRule        = errors.New("Error invalid ACL rule")
+	ErrACLDefaultUser = errors.New("Error the 'default' user cannot be removed")
+	ErrACLNoFile      = errors.New("Error no ACL file is configured")
+)
+
+// DefaultUser is the user that connections are authenticated as before AUTH,
+// if it's enabled and has no password.
+const DefaultUser = "default"
+
+// userKey is the session value of the name of the authenticated user.
+type userKey struct{}
+
+// User is a user of ACL.
+type User struct {
+	Name      string
+	Enabled   bool
+	NoPass    bool
+	Passwords []string // The hex of the SHA-256 of the passwords
+	Commands  []string // The rules of commands in order, like +@read, -del
+	Keys      []string // The prefixes of the keys allowed ending with '*', like cache:*, or single keys
+}
+
+// NewUser returns a disabled user with no permissions.
+func NewUser(name string) *User {
+	return &User{
+		Name: name,
+	}
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetRules applies the rules in the syntax of ACL SETUSER of Redis, in order:
+//
+//	on, off                 enables or disables the user
+//	>password, <password    adds or removes a password
+//	#hash, !hash            adds or removes the hex of the SHA-256 of a password
+//	nopass, resetpass       allows any password, or removes all of them
+//	+@category, -@category  allows or disallows a category, which is read, write, admin or all
+//	+command, -command      allows or disallows a command
+//	allcommands, nocommands the same as +@all and -@all
+//	~prefix*, ~key          allows the keys with the prefix, like ~cache:*, or a single key
+//	allkeys, resetkeys      the same as ~*, or removes all of the patterns
+//	reset                   removes everything
+func (u *User) SetRules(rules ...string) error {
+	for _, rule := range rules {
+		err := u.setRule(rule)
+		if err != nil {
+			return fmt.Errorf("%s '%s'", ErrACLRule, rule)
+		}
+	}
+	return nil
+}
+
+func (u *User) setRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.Enabled = true
+		return nil
+	case "off":
+		u.Enabled = false
+		return nil
+	case "nopass":
+		u.NoPass = true
+		u.Passwords = nil
+		return nil
+	case "resetpass":
+		u.NoPass = false
+		u.Passwords = nil
+		return nil
+	case "allcommands":
+		u.Commands = []string{"+@all"}
+		return nil
+	case "nocommands":
+		u.Commands = nil
+		return nil
+	case "allkeys":
+		u.Keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		u.Keys = nil
+		return nil
+	case "reset":
+		*u = User{Name: u.Name}
+		return nil
+	}
+	if len(rule) < 2 {
+		return ErrACLRule
+	}
+
+	switch rule[0] {
+	case '>':
+		u.addPassword(hashPassword(rule[1:]))
+	case '<':
+		u.removePassword(hashPassword(rule[1:]))
+	case '#':
+		hash := strings.ToLower(rule[1:])
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return ErrACLRule
+		}
+		u.addPassword(hash)
+	case '!':
+		u.removePassword(strings.ToLower(rule[1:]))
+	case '~':
+		// Only a trailing '*' is allowed, so the rules are prefixes that ranges can be checked against.
+		if strings.ContainsAny(strings.TrimSuffix(rule[1:], "*"), "*?[\\") {
+			return ErrACLRule
+		}
+		u.Keys = append(u.Keys, rule[1:])
+	case '+', '-':
+		rule = strings.ToLower(rule)
+		if strings.HasPrefix(rule[1:], "@") {
+			switch rule[2:] {
+			default:
+				return ErrACLRule
+			case "all":
+				// The previous rules make no difference.
+				u.Commands = nil
+			case CategoryRead, CategoryWrite, CategoryAdmin:
+			}
+		}
+		u.Commands = append(u.Commands, rule)
+	default:
+		return ErrACLRule
+	}
+	return nil
+}
+
+func (u *User) addPassword(hash string) {
+	u.NoPass = false
+	for _, p := range u.Passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.Passwords = append(u.Passwords, hash)
+}
+
+func (u *User) removePassword(hash string) {
+	for i, p := range u.Passwords {
+		if p == hash {
+			u.Passwords = append(u.Passwords[:i:i], u.Passwords[i+1:]...)
+			return
+		}
+	}
+}
+
+// CheckPassword returns if the password is one of the user.
+func (u *User) CheckPassword(password string) bool {
+	if u.NoPass {
+		return true
+	}
+	hash := []byte(hashPassword(password))
+	ok := false
+	for _, p := range u.Passwords {
+		if subtle.ConstantTimeCompare([]byte(p), hash) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// CanRun returns if the user is allowed to run the command of category.
+func (u *User) CanRun(name, category string) bool {
+	if category == "" {
+		return true
+	}
+	allowed := false
+	for _, rule := range u.Commands {
+		target := rule[1:]
+		match := target == name ||
+			target == "@all" ||
+			target == "@"+category
+		if match {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// CanAccess returns if the user is allowed to access the key.
+func (u *User) CanAccess(key string) bool {
+	for _, rule := range u.Keys {
+		if strings.HasSuffix(rule, "*") {
+			if strings.HasPrefix(key, rule[:len(rule)-1]) {
+				return true
+			}
+		} else if key == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessRange returns if all the keys from start to limit have one of the prefixes allowed,
+// the empty bounds, which mean no bound, are allowed only with all the keys.
+func (u *User) CanAccessRange(start, limit string) bool {
+	for _, rule := range u.Keys {
+		if !strings.HasSuffix(rule, "*") {
+			continue
+		}
+		prefix := rule[:len(rule)-1]
+		if prefix == "" {
+			return true
+		}
+		if start == "" || limit == "" || start < prefix {
+			continue
+		}
+		if next, ok := prefixLimit(prefix); !ok || limit < next {
+			return true
+		}
+	}
+	return false
+}
+
+// keyspaceChannels is the prefix of the channels of the keyspace events, which have the keys in them.
+const keyspaceChannels = "__key"
+
+// CanSubscribe returns if the user is allowed to subscribe to the channel,
+// the channels of the keyspace events are allowed only for the keys allowed.
+func (u *User) CanSubscribe(channel string) bool {
+	if !strings.HasPrefix(channel, keyspaceChannels) || u.CanAccessRange("", "") {
+		return true
+	}
+	const prefix = "__keyspace@0__:"
+	return strings.HasPrefix(channel, prefix) && u.CanAccess(channel[len(prefix):])
+}
+
+// CanPSubscribe returns if the user is allowed to subscribe to the glob pattern,
+// the patterns that may match the channels of the keyspace events are allowed only with all the keys.
+func (u *User) CanPSubscribe(pattern string) bool {
+	if u.CanAccessRange("", "") {
+		return true
+	}
+	literal := pattern
+	if i := strings.IndexAny(pattern, "*?[\\"); i != -1 {
+		literal = pattern[:i]
+	}
+	return !strings.HasPrefix(literal, keyspaceChannels) && !strings.HasPrefix(keyspaceChannels, literal)
+}
+
+// prefixLimit returns the least string greater than all the strings with the prefix,
+// ok is false if there is none.
+func prefixLimit(prefix string) (string, bool) {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1}), true
+		}
+	}
+	return "", false
+}
+
+// String returns the user in the format of ACL LIST, which is also a line of the ACL file.
+func (u *User) String() string {
+	rules := []string{"user", u.Name}
+	if u.Enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if u.NoPass {
+		rules = append(rules, "nopass")
+	}
+	for _, p := range u.Passwords {
+		rules = append(rules, "#"+p)
+	}
+	for _, k := range u.Keys {
+		rules = append(rules, "~"+k)
+	}
+	if len(u.Commands) == 0 {
+		rules = append(rules, "-@all")
+	}
+	rules = append(rules, u.Commands...)
+	return strings.Join(rules, " ")
+}
+
+func (u *User) clone() *User {
+	n := *u
+	n.Passwords = append([]string(nil), u.Passwords...)
+	n.Commands = append([]string(nil), u.Commands...)
+	n.Keys = append([]string(nil), u.Keys...)
+	return &n
+}
+
+// ACL is the users and their permissions.
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+	file  string
+}
+
+// NewACL returns an ACL with the default user, which can do anything without a password,
+// unless requirePass is set.
+func NewACL(requirePass string) *ACL {
+	user := NewUser(DefaultUser)
+	user.SetRules("on", "allkeys", "allcommands")
+	if requirePass != "" {
+		user.SetRules(">" + requirePass)
+	} else {
+		user.SetRules("nopass")
+	}
+	return &ACL{
+		users: map[string]*User{
+			DefaultUser: user,
+		},
+	}
+}
+
+// LoadFile loads the users from the ACL file, which has a user on each line in the format of ACL LIST,
+// empty lines and lines starting with '#' are ignored.
+// The users are replaced only if the whole file is valid, the default user is kept if it's not in the file.
+// The file is remembered for ACL LOAD and ACL SAVE.
+func (a *ACL) LoadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users := map[string]*User{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("Error %s:%d: should start with 'user <name>'", file, n)
+		}
+		user := NewUser(fields[1])
+		err := user.SetRules(fields[2:]...)
+		if err != nil {
+			return fmt.Errorf("Error %s:%d: %s", file, n, err)
+		}
+		users[user.Name] = user
+	}
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = a.users[DefaultUser]
+	}
+	a.users = users
+	a.file = file
+	return nil
+}
+
+// Load reloads the ACL file.
+func (a *ACL) Load() error {
+	a.mu.RLock()
+	file := a.file
+	a.mu.RUnlock()
+	if file == "" {
+		return ErrACLNoFile
+	}
+	return a.LoadFile(file)
+}
+
+// Save writes the users to the ACL file.
+func (a *ACL) Save() error {
+	a.mu.RLock()
+	file := a.file
+	list := a.list()
+	a.mu.RUnlock()
+	if file == "" {
+		return ErrACLNoFile
+	}
+
+	tmp := file + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strings.Join(list, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// User returns a copy of the user.
+func (a *ACL) User(name string) (*User, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user, ok := a.users[name]
+	if !ok {
+		return nil, false
+	}
+	return user.clone(), true
+}
+
+// SetUser creates or modifies the user with the rules.
+func (a *ACL) SetUser(name string, rules ...string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user, ok := a.users[name]
+	if ok {
+		user = user.clone()
+	} else {
+		user = NewUser(name)
+	}
+	err := user.SetRules(rules...)
+	if err != nil {
+		return err
+	}
+	a.users[name] = user
+	return nil
+}
+
+// DelUser removes the users and returns the number of them removed.
+func (a *ACL) DelUser(names ...string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, ErrACLDefaultUser
+		}
+	}
+	n := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// List returns the users in the format of ACL LIST.
+func (a *ACL) List() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.list()
+}
+
+func (a *ACL) list() []string {
+	list := make([]string, 0, len(a.users))
+	for _, user := range a.users {
+		list = append(list, user.String())
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Auth checks the password of the user.
+func (a *ACL) Auth(name, password string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user, ok := a.users[name]
+	return ok && user.Enabled && user.CheckPassword(password)
+}
+
+// whoami returns the user that the session is authenticated as.
+func (a *ACL) whoami(s *lrdb.Session) (*User, bool) {
+	name, ok := s.Value(userKey{}).(string)
+	if !ok {
+		name = DefaultUser
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user, ok := a.users[name]
+	if !ok || !user.Enabled {
+		return nil, false
+	}
+	// The default user needs no AUTH only without a password.
+	if name == DefaultUser && s.Value(userKey{}) == nil && !user.NoPass {
+		return nil, false
+	}
+	return user, true
+}
+
+// check returns the error if the session is not allowed to run the command, args start with the name.
+func (a *ACL) check(s *lrdb.Session, info *CommandInfo, name string, args []resp.Reply) error {
+	if info.HasFlag("noauth") {
+		return nil
+	}
+	user, ok := a.whoami(s)
+	if !ok {
+		return ErrNoAuth
+	}
+
+	category := info.Category
+	if subs, ok := selfSubcommands[name]; ok && len(args) >= 2 {
+		var sub string
+		if resp.ConvertFrom(args[1], &sub) == nil && subs[strings.ToLower(sub)] {
+			category = ""
+		}
+	}
+	if !user.CanRun(name, category) {
+		return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", name)
+	}
+
+	keys := info.Keys(args)
+	if info.HasFlag("range") {
+		// The bounds are not keys, the whole range must be in a prefix allowed.
+		var bounds []string
+		err := resp.ConvertFrom(resp.ReplyMultiBulk(keys), &bounds)
+		if err != nil {
+			return err
+		}
+		if len(bounds) != 2 || !user.CanAccessRange(bounds[0], bounds[1]) {
+			return ErrNoPermKeys
+		}
+		keys = nil
+	}
+	for _, arg := range keys {
+		var key string
+		err := resp.ConvertFrom(arg, &key)
+		if err != nil {
+			return err
+		}
+		if !user.CanAccess(key) {
+			return ErrNoPermKeys
+		}
+	}
+
+	switch name {
+	case "subscribe", "psubscribe":
+		for _, arg := range args[1:] {
+			var channel string
+			err := resp.ConvertFrom(arg, &channel)
+			if err != nil {
+				return err
+			}
+			if name == "subscribe" && !user.CanSubscribe(channel) ||
+				name == "psubscribe" && !user.CanPSubscribe(channel) {
+				return ErrNoPermChannels
+			}
+		}
+	case "publish":
+		// Publishing to the channels of the keyspace events would fake the events of the keys.
+		var channel string
+		err := resp.ConvertFrom(args[1], &channel)
+		if err != nil {
+			return err
+		}
+		if !user.CanSubscribe(channel) {
+			return ErrNoPermChannels
+		}
+	}
+	return nil
+}
+
+func (c *Commands) cmdAuth(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	var username, password string
+	switch len(args) {
+	default:
+		return nil, ErrWrongNumberOfArguments
+	case 1:
+		username = DefaultUser
+		err := resp.ConvertFrom(args[0], &password)
+		if err != nil {
+			return nil, err
+		}
+	case 2:
+		err := resp.ConvertFrom(args[0], &username)
+		if err != nil {
+			return nil, err
+		}
+		err = resp.ConvertFrom(args[1], &password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.acl == nil {
+		return nil, ErrAuthNotEnabled
+	}
+	if !c.acl.Auth(username, password) {
+		return nil, ErrWrongPass
+	}
+	s.SetValue(userKey{}, username)
+	return reply.OK, nil
+}
+
+func (c *Commands) cmdACL(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) == 0 {
+		return nil, ErrWrongNumberOfArguments
+	}
+	if c.acl == nil {
+		return nil, ErrAuthNotEnabled
+	}
+	var sub string
+	err := resp.ConvertFrom(args[0], &sub)
+	if err != nil {
+		return nil, err
+	}
+	var rest []string
+	err = resp.ConvertFrom(resp.ReplyMultiBulk(args[1:]), &rest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(sub) {
+	default:
+		return nil, fmt.Errorf("Error unknown subcommand '%s'", sub)
+	case "setuser":
+		if len(rest) == 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		err = c.acl.SetUser(rest[0], rest[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		return reply.OK, nil
+	case "deluser":
+		if len(rest) == 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		n, err := c.acl.DelUser(rest...)
+		if err != nil {
+			return nil, err
+		}
+		return resp.ConvertTo(n)
+	case "list":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		return resp.ConvertTo(c.acl.List())
+	case "whoami":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		user, ok := c.acl.whoami(s)
+		if !ok {
+			return nil, ErrNoAuth
+		}
+		return resp.ReplyBulk(user.Name), nil
+	case "load":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		err = c.acl.Load()
+		if err != nil {
+			return nil, err
+		}
+		return reply.OK, nil
+	case "save":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		err = c.acl.Save()
+		if err != nil {
+			return nil, err
+		}
+		return reply.OK, nil
+	}
+}
@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+var (
+	ErrNoClient     = errors.New("Error the connection is not a registered client")
+	ErrNoSuchClient = errors.New("Error No such client")
+	ErrClientName   = errors.New("Error Client names cannot contain spaces, newlines or special characters.")
+)
+
+func (c *Commands) cmdClient(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) == 0 {
+		return nil, ErrWrongNumberOfArguments
+	}
+	client := s.Client()
+	if client == nil {
+		return nil, ErrNoClient
+	}
+	var sub string
+	err := resp.ConvertFrom(args[0], &sub)
+	if err != nil {
+		return nil, err
+	}
+	var rest []string
+	err = resp.ConvertFrom(resp.ReplyMultiBulk(args[1:]), &rest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(sub) {
+	default:
+		return nil, fmt.Errorf("Error unknown subcommand '%s'", sub)
+	case "id":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		return resp.ConvertTo(int64(client.ID()))
+	case "getname":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		name := client.Name()
+		if name == "" {
+			return resp.ReplyBulk(nil), nil
+		}
+		return resp.ReplyBulk(name), nil
+	case "setname":
+		if len(rest) != 1 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		if !validClientName(rest[0]) {
+			return nil, ErrClientName
+		}
+		client.SetName(rest[0])
+		return reply.OK, nil
+	case "list":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		buf := strings.Builder{}
+		for _, cli := range client.Registry().List() {
+			info := cli.Info()
+			fmt.Fprintf(&buf, "id=%d addr=%s name=%s age=%d idle=%d cmd=%s tot-net-in=%d tot-net-out=%d\n",
+				info.ID, info.Addr, info.Name, info.Age/time.Second, info.Idle/time.Second,
+				info.LastCmd, info.BytesIn, info.BytesOut)
+		}
+		return resp.ReplyBulk(buf.String()), nil
+	case "kill":
+		return clientKill(client, rest)
+	}
+}
+
+// validClientName returns if name has only printable characters without spaces.
+func validClientName(name string) bool {
+	for _, r := range name {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientKill kills the clients by CLIENT KILL addr, which replies OK,
+// or by CLIENT KILL [ID id] [ADDR addr] [SKIPME yes/no], which replies the number of killed clients.
+func clientKill(client *lrdb.Client, args []string) (resp.Reply, error) {
+	switch len(args) {
+	case 0:
+		return nil, ErrWrongNumberOfArguments
+	case 1:
+		for _, cli := range client.Registry().List() {
+			if cli.Info().Addr == args[0] {
+				cli.Kill()
+				return reply.OK, nil
+			}
+		}
+		return nil, ErrNoSuchClient
+	}
+	if len(args)%2 != 0 {
+		return nil, ErrSyntax
+	}
+
+	var id uint64
+	var addr string
+	skipme := true
+	for i := 0; i != len(args); i += 2 {
+		val := args[i+1]
+		switch strings.ToLower(args[i]) {
+		default:
+			return nil, ErrSyntax
+		case "id":
+			n, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return nil, ErrSyntax
+			}
+			id = n
+		case "addr":
+			addr = val
+		case "skipme":
+			switch strings.ToLower(val) {
+			default:
+				return nil, ErrSyntax
+			case "yes":
+				skipme = true
+			case "no":
+				skipme = false
+			}
+		}
+	}
+
+	killed := 0
+	for _, cli := range client.Registry().List() {
+		if id != 0 && cli.ID() != id {
+			continue
+		}
+		if addr != "" && cli.Info().Addr != addr {
+			continue
+		}
+		if skipme && cli == client {
+			continue
+		}
+		cli.Kill()
+		killed++
+	}
+	return resp.ConvertTo(killed)
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/resp"
+)
+
+var (
+	ErrInvalidCommand = errors.New("Error Invalid command specified")
+	ErrInvalidArity   = errors.New("Error Invalid number of arguments specified for command")
+	ErrNoKeys         = errors.New("Error The command has no key arguments")
+)
+
+// cmdCommand describes the commands by COMMAND [COUNT | INFO name... | DOCS [name...] | GETKEYS command arg...].
+func (c *Commands) cmdCommand(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) == 0 {
+		return c.commandInfoReply(c.commandNames()), nil
+	}
+	var sub string
+	err := resp.ConvertFrom(args[0], &sub)
+	if err != nil {
+		return nil, err
+	}
+	var rest []string
+	err = resp.ConvertFrom(resp.ReplyMultiBulk(args[1:]), &rest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(sub) {
+	default:
+		return nil, fmt.Errorf("Error unknown subcommand '%s'", sub)
+	case "count":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		return resp.ConvertTo(int64(len(c.method)))
+	case "info":
+		if len(rest) == 0 {
+			rest = c.commandNames()
+		}
+		names := make([]string, 0, len(rest))
+		for _, name := range rest {
+			names = append(names, strings.ToLower(name))
+		}
+		return c.commandInfoReply(names), nil
+	case "docs":
+		if len(rest) == 0 {
+			rest = c.commandNames()
+		}
+		docs := resp.ReplyMultiBulk{}
+		for _, name := range rest {
+			name = strings.ToLower(name)
+			info, ok := c.Info(name)
+			if !ok {
+				continue
+			}
+			docs = append(docs, resp.ReplyBulk(name), lrdb.Map{ReplyMultiBulk: resp.ReplyMultiBulk{
+				resp.ReplyBulk("summary"), resp.ReplyBulk(info.Summary),
+			}})
+		}
+		return lrdb.Map{ReplyMultiBulk: docs}, nil
+	case "getkeys":
+		if len(args) < 2 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		info, ok := c.Info(strings.ToLower(rest[0]))
+		if !ok {
+			return nil, ErrInvalidCommand
+		}
+		if !info.CheckArity(args[1:]) {
+			return nil, ErrInvalidArity
+		}
+		keys := info.Keys(args[1:])
+		if len(keys) == 0 {
+			return nil, ErrNoKeys
+		}
+		return resp.ReplyMultiBulk(keys), nil
+	}
+}
+
+// commandNames returns the names of the added commands in order.
+func (c *Commands) commandNames() []string {
+	names := make([]string, 0, len(c.method))
+	for name := range c.method {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandInfoReply replies the info of the commands like Redis,
+// each is name, arity, flags, first key, last key, step and ACL categories, or nil if it's not added.
+func (c *Commands) commandInfoReply(names []string) resp.Reply {
+	infos := make(resp.ReplyMultiBulk, 0, len(names))
+	for _, name := range names {
+		info, ok := c.Info(name)
+		if !ok {
+			infos = append(infos, resp.ReplyMultiBulk(nil))
+			continue
+		}
+		flags := resp.ReplyMultiBulk{}
+		for _, flag := range info.AllFlags() {
+			flags = append(flags, resp.ReplyStatus(flag))
+		}
+		categories := resp.ReplyMultiBulk{}
+		if info.Category != "" {
+			categories = append(categories, resp.ReplyStatus("@"+info.Category))
+		}
+		infos = append(infos, resp.ReplyMultiBulk{
+			resp.ReplyBulk(name),
+			resp.ReplyInteger(strconv.Itoa(info.Arity)),
+			flags,
+			resp.ReplyInteger(strconv.Itoa(info.FirstKey)),
+			resp.ReplyInteger(strconv.Itoa(info.LastKey)),
+			resp.ReplyInteger(strconv.Itoa(info.Step)),
+			categories,
+		})
+	}
+	return infos
+}
@@ -2,32 +2,140 @@ package engine
 
 import (
 	"fmt"
+	"io"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/reply"
 	"github.com/wzshiming/resp"
 )
 
 type Commands struct {
-	method map[string]lrdb.CmdFunc
-	ohter  lrdb.CmdFunc
+	method      map[string]lrdb.CmdFunc
+	info        map[string]*CommandInfo
+	ohter       lrdb.CmdFunc
+	middlewares []Middleware
+	handler     lrdb.CmdFunc
+	transactor  Transactor
+	closer      io.Closer
+	acl         *ACL
+	limiter     *RateLimiter
+	stater      Stater
+	slowlog     *SlowLog
+	config      *lrdb.Config
+}
+
+// Stater is the storage that reports its statistics in INFO.
+type Stater interface {
+	// Stats returns the statistics as pairs of names and values.
+	Stats() (resp.ReplyMultiBulk, error)
 }
 
 func NewCommands(ohter lrdb.CmdFunc) *Commands {
 	c := &Commands{
 		ohter:  ohter,
 		method: map[string]lrdb.CmdFunc{},
+		info:   map[string]*CommandInfo{},
+		// The defaults of slowlog-log-slower-than and slowlog-max-len of Redis.
+		slowlog: NewSlowLog(10*time.Millisecond, 128),
 	}
+	c.Use(SlowLogging(c.slowlog))
 	c.registe()
 	return c
 }
 
+// AddCommand adds the command, the builtin ones are described by the command table.
 func (c *Commands) AddCommand(name string, cmd lrdb.CmdFunc) {
+	c.AddCommandInfo(name, commandTable[name], cmd)
+}
+
+// AddCommandInfo adds the command described by info, the arity is checked before it's run,
+// and the category and the keys are used by ACL. A nil info is taken as an admin command without keys.
+func (c *Commands) AddCommandInfo(name string, info *CommandInfo, cmd lrdb.CmdFunc) {
 	c.method[name] = cmd
+	if info == nil {
+		delete(c.info, name)
+		return
+	}
+	c.info[name] = info
 }
 
-func (c *Commands) Cmd(r resp.Reply) (resp.Reply, error) {
+// Info returns the info of the command, false if it's not added.
+func (c *Commands) Info(name string) (*CommandInfo, bool) {
+	if _, ok := c.method[name]; !ok {
+		return nil, false
+	}
+	return c.commandInfo(name), true
+}
+
+// commandInfo returns the info of the command, the unknown ones are taken as admin commands without keys.
+func (c *Commands) commandInfo(name string) *CommandInfo {
+	info, ok := c.info[name]
+	if !ok {
+		return unknownCommand
+	}
+	return info
+}
+
+// Use adds middlewares that wrap every command, including the ones added later and the fallback,
+// the first one added is the outermost, and all of them wrap the checks of the arity and ACL.
+// It must be called before the commands are served.
+func (c *Commands) Use(middlewares ...Middleware) {
+	c.middlewares = append(c.middlewares, middlewares...)
+	handler := c.checked(c.dispatch)
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](handler)
+	}
+	c.handler = handler
+}
+
+// SetTransactor sets the transactor that EXEC runs the queued commands with.
+func (c *Commands) SetTransactor(t Transactor) {
+	c.transactor = t
+}
+
+// SetACL enables the authentication and the permissions of the users in acl.
+func (c *Commands) SetACL(acl *ACL) {
+	c.acl = acl
+}
+
+// SetRateLimiter limits the read and the write commands with limiter, by a middleware added with Use.
+func (c *Commands) SetRateLimiter(limiter *RateLimiter) {
+	c.limiter = limiter
+	c.Use(c.rateLimiting)
+}
+
+// SetStater sets the storage whose statistics are replied by INFO.
+func (c *Commands) SetStater(stater Stater) {
+	c.stater = stater
+}
+
+// SetConfig sets the parameters of the server that CONFIG gets, sets and rewrites.
+func (c *Commands) SetConfig(config *lrdb.Config) {
+	c.config = config
+}
+
+// SlowLog returns the slow log of the commands.
+func (c *Commands) SlowLog() *SlowLog {
+	return c.slowlog
+}
+
+// SetCloser sets the storage that is closed by Close.
+func (c *Commands) SetCloser(closer io.Closer) {
+	c.closer = closer
+}
+
+// Close closes the storage of the commands, it's called when the server is shut down.
+func (c *Commands) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+func (c *Commands) Cmd(s *lrdb.Session, r resp.Reply) (resp.Reply, error) {
 	switch t := r.(type) {
 	default:
 		return nil, ErrUnsupportedForm
@@ -35,25 +143,85 @@ func (c *Commands) Cmd(r resp.Reply) (resp.Reply, error) {
 		if len(t) == 0 {
 			return nil, ErrEmptyData
 		}
-		return c.cmd(t[:])
+		return c.cmd(s, t[:])
 	}
 }
 
-func (c *Commands) cmd(args []resp.Reply) (resp.Reply, error) {
+func (c *Commands) cmd(s *lrdb.Session, args []resp.Reply) (resp.Reply, error) {
 	switch t := args[0].(type) {
 	default:
 		return nil, ErrUnsupportedForm
 	case resp.ReplyBulk:
 		name := *(*string)(unsafe.Pointer(&t))
 		name = strings.ToLower(name)
-		args = args[1:]
-		fun, ok := c.method[name]
-		if !ok {
-			if c.ohter != nil {
-				return c.ohter(name, args)
+		if s.InMulti() && !immediate[name] {
+			// Checked when it's queued too, so an error aborts the transaction.
+			err := c.check(s, name, args)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := c.method[name]; !ok && c.ohter == nil {
+				s.Abort()
+				return nil, fmt.Errorf("Error Unknown Command '%s'", name)
+			}
+			s.Queue(args)
+			return reply.QUEUED, nil
+		}
+		return c.handler(s, name, args[1:])
+	}
+}
+
+// checked is the innermost middleware, it checks the command before next runs it and feeds it to the monitors,
+// so the errors of the checks are seen by the other middlewares, like the ones of the metrics.
+func (c *Commands) checked(next lrdb.CmdFunc) lrdb.CmdFunc {
+	return func(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+		cmd := append(resp.ReplyMultiBulk{resp.ReplyBulk(name)}, args...)
+		err := c.check(s, name, cmd)
+		if err != nil {
+			return nil, err
+		}
+		// Fed after the checks, so the monitors don't see the commands refused by ACL.
+		if broker := s.Broker(); broker != nil {
+			broker.Feed(s.Addr(), cmd)
+		}
+		return next(s, name, args)
+	}
+}
+
+// check checks the arity, the permissions of ACL and the subscriber mode of the command, args start with the name.
+// An error of the arity or the permissions aborts the transaction.
+func (c *Commands) check(s *lrdb.Session, name string, args []resp.Reply) error {
+	_, ok := c.method[name]
+	info := c.commandInfo(name)
+	if ok && !info.CheckArity(args) {
+		if s.InMulti() {
+			s.Abort()
+		}
+		return ErrWrongNumberOfArguments
+	}
+	if c.acl != nil {
+		err := c.acl.check(s, info, name, args)
+		if err != nil {
+			if s.InMulti() {
+				s.Abort()
 			}
-			return nil, fmt.Errorf("Error Unknown Command '%s'", name)
+			return err
+		}
+	}
+	if s.Subscribed() && s.Protocol() != lrdb.RESP3 && !subscriberMode[name] {
+		return ErrSubscriberMode
+	}
+	return nil
+}
+
+// dispatch runs the command by name, it's the innermost handler.
+func (c *Commands) dispatch(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	fun, ok := c.method[name]
+	if !ok {
+		if c.ohter != nil {
+			return c.ohter(s, name, args)
 		}
-		return fun(name, args)
+		return nil, fmt.Errorf("Error Unknown Command '%s'", name)
 	}
+	return fun(s, name, args)
 }
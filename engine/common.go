@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"strings"
 	"time"
 
 	"github.com/wzshiming/lrdb"
@@ -8,7 +9,7 @@ import (
 	"github.com/wzshiming/resp"
 )
 
-func (c *Commands) cmdEcho(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *Commands) cmdEcho(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, ErrWrongNumberOfArguments
@@ -17,7 +18,7 @@ func (c *Commands) cmdEcho(name string, args []resp.Reply) (resp.Reply, error) {
 	}
 }
 
-func (c *Commands) cmdPing(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *Commands) cmdPing(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, ErrWrongNumberOfArguments
@@ -28,11 +29,32 @@ func (c *Commands) cmdPing(name string, args []resp.Reply) (resp.Reply, error) {
 	}
 }
 
-func (c *Commands) cmdQuit(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *Commands) cmdQuit(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	return reply.OK, lrdb.ErrQuit
 }
 
-func (c *Commands) cmdTime(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *Commands) cmdShutdown(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, ErrWrongNumberOfArguments
+	case 0:
+	case 1:
+		// NOSAVE and SAVE are accepted for compatibility, everything is persisted anyway.
+		var mode string
+		err := resp.ConvertFrom(args[0], &mode)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(mode) {
+		default:
+			return nil, ErrSyntax
+		case "nosave", "save":
+		}
+	}
+	return nil, lrdb.ErrShutdown
+}
+
+func (c *Commands) cmdTime(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	un := time.Now()
 	nano := int64(un.Nanosecond())
 	unix := un.Unix()
@@ -43,9 +65,45 @@ func (c *Commands) cmdTime(name string, args []resp.Reply) (resp.Reply, error) {
 	})
 }
 
+func (c *Commands) cmdInfo(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	stats := resp.ReplyMultiBulk{}
+	if c.stater != nil {
+		r, err := c.stater.Stats()
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, r...)
+	}
+	if c.limiter != nil {
+		stats = append(stats, c.limiter.Stats()...)
+	}
+	return lrdb.Map{ReplyMultiBulk: stats}, nil
+}
+
 func (c *Commands) registe() {
 	c.AddCommand("echo", c.cmdEcho)
 	c.AddCommand("ping", c.cmdPing)
 	c.AddCommand("quit", c.cmdQuit)
 	c.AddCommand("time", c.cmdTime)
+	c.AddCommand("info", c.cmdInfo)
+	c.AddCommand("shutdown", c.cmdShutdown)
+	c.AddCommand("client", c.cmdClient)
+	c.AddCommand("command", c.cmdCommand)
+	c.AddCommand("slowlog", c.cmdSlowLog)
+	c.AddCommand("monitor", c.cmdMonitor)
+	c.AddCommand("config", c.cmdConfig)
+
+	c.AddCommand("auth", c.cmdAuth)
+	c.AddCommand("hello", c.cmdHello)
+	c.AddCommand("acl", c.cmdACL)
+
+	c.AddCommand("multi", c.cmdMulti)
+	c.AddCommand("exec", c.cmdExec)
+	c.AddCommand("discard", c.cmdDiscard)
+
+	c.AddCommand("subscribe", c.cmdSubscribe)
+	c.AddCommand("psubscribe", c.cmdPSubscribe)
+	c.AddCommand("unsubscribe", c.cmdUnsubscribe)
+	c.AddCommand("punsubscribe", c.cmdPUnsubscribe)
+	c.AddCommand("publish", c.cmdPublish)
 }
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+var ErrConfigNotEnable = errors.New("Error CONFIG is not enabled")
+
+// cmdConfig gets, sets and rewrites the parameters by CONFIG GET pattern [pattern ...] | SET name value [name value ...] | REWRITE.
+func (c *Commands) cmdConfig(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if c.config == nil {
+		return nil, ErrConfigNotEnable
+	}
+	var sub string
+	err := resp.ConvertFrom(args[0], &sub)
+	if err != nil {
+		return nil, err
+	}
+	var rest []string
+	err = resp.ConvertFrom(resp.ReplyMultiBulk(args[1:]), &rest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(sub) {
+	default:
+		return nil, fmt.Errorf("Error unknown subcommand '%s'", sub)
+	case "get":
+		if len(rest) == 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		params := resp.ReplyMultiBulk{}
+		seen := map[string]bool{}
+		for _, pattern := range rest {
+			list := c.config.Get(pattern)
+			for i := 0; i != len(list); i += 2 {
+				if seen[list[i]] {
+					continue
+				}
+				seen[list[i]] = true
+				params = append(params, resp.ReplyBulk(list[i]), resp.ReplyBulk(list[i+1]))
+			}
+		}
+		return lrdb.Map{ReplyMultiBulk: params}, nil
+	case "set":
+		if len(rest) == 0 || len(rest)%2 != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		err = c.config.Set(rest...)
+		if err != nil {
+			return nil, err
+		}
+		return reply.OK, nil
+	case "rewrite":
+		if len(rest) != 0 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		err = c.config.Rewrite()
+		if err != nil {
+			return nil, err
+		}
+		return reply.OK, nil
+	}
+}
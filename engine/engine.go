@@ -8,4 +8,6 @@ var (
 	ErrWrongNumberOfArguments = errors.New("Error wrong number of arguments")
 	ErrUnsupportedForm        = errors.New("Error unsupported form")
 	ErrEmptyData              = errors.New("Error empty data")
+	ErrSyntax                 = errors.New("Error syntax error")
+	ErrWrongType              = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 )
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/resp"
+)
+
+var ErrNoProto = errors.New("NOPROTO unsupported protocol version")
+
+// cmdHello switches the protocol of the connection by HELLO [protover [AUTH username password] [SETNAME clientname]],
+// and replies the properties of the connection.
+func (c *Commands) cmdHello(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	protocol := s.Protocol()
+	if len(args) != 0 {
+		var version int64
+		err := resp.ConvertFrom(args[0], &version)
+		if err != nil {
+			return nil, ErrNoProto
+		}
+		switch version {
+		default:
+			return nil, ErrNoProto
+		case lrdb.RESP2, lrdb.RESP3:
+		}
+		protocol = int(version)
+		args = args[1:]
+	}
+
+	var opts []string
+	err := resp.ConvertFrom(resp.ReplyMultiBulk(args), &opts)
+	if err != nil {
+		return nil, err
+	}
+	var username, password, clientName string
+	var auth, setName bool
+	for len(opts) != 0 {
+		switch strings.ToLower(opts[0]) {
+		default:
+			return nil, ErrSyntax
+		case "auth":
+			if len(opts) < 3 {
+				return nil, ErrSyntax
+			}
+			auth = true
+			username, password = opts[1], opts[2]
+			opts = opts[3:]
+		case "setname":
+			if len(opts) < 2 {
+				return nil, ErrSyntax
+			}
+			setName = true
+			clientName = opts[1]
+			opts = opts[2:]
+		}
+	}
+
+	if auth {
+		if c.acl == nil {
+			return nil, ErrAuthNotEnabled
+		}
+		if !c.acl.Auth(username, password) {
+			return nil, ErrWrongPass
+		}
+		s.SetValue(userKey{}, username)
+	} else if c.acl != nil {
+		_, ok := c.acl.whoami(s)
+		if !ok {
+			return nil, ErrNoAuth
+		}
+	}
+
+	client := s.Client()
+	if setName {
+		if client == nil {
+			return nil, ErrNoClient
+		}
+		if !validClientName(clientName) {
+			return nil, ErrClientName
+		}
+		client.SetName(clientName)
+	}
+	var id uint64
+	if client != nil {
+		id = client.ID()
+	}
+
+	s.SetProtocol(protocol)
+	return lrdb.Map{ReplyMultiBulk: resp.ReplyMultiBulk{
+		resp.ReplyBulk("server"), resp.ReplyBulk("lrdb"),
+		resp.ReplyBulk("proto"), resp.ReplyInteger(strconv.Itoa(protocol)),
+		resp.ReplyBulk("id"), resp.ReplyInteger(strconv.FormatUint(id, 10)),
+		resp.ReplyBulk("mode"), resp.ReplyBulk("standalone"),
+		resp.ReplyBulk("role"), resp.ReplyBulk("master"),
+		resp.ReplyBulk("modules"), resp.ReplyMultiBulk{},
+	}}, nil
+}
@@ -1,15 +1,17 @@
 package leveldb
 
 import (
+	"bytes"
 	"math"
 
-	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/wzshiming/lrdb"
 	"github.com/wzshiming/lrdb/engine"
 	"github.com/wzshiming/lrdb/reply"
 	"github.com/wzshiming/resp"
 )
 
-func (c *LevelDB) get(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) get(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -19,7 +21,7 @@ func (c *LevelDB) get(name string, args []resp.Reply) (resp.Reply, error) {
 		if err != nil {
 			return nil, err
 		}
-		val, err := c.db.Get(key, nil)
+		val, _, err := getString(c.reader(s), key)
 		if err != nil {
 			return nil, err
 		}
@@ -27,7 +29,7 @@ func (c *LevelDB) get(name string, args []resp.Reply) (resp.Reply, error) {
 	}
 }
 
-func (c *LevelDB) set(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) set(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -43,7 +45,36 @@ func (c *LevelDB) set(name string, args []resp.Reply) (resp.Reply, error) {
 			return nil, err
 		}
 
-		err = c.db.Put(key, val, nil)
+		// Outside MULTI/EXEC it's written by a batch, a transaction is exclusive and flushes the memtable.
+		if c.exec(s) == nil {
+			err = c.setBatch(key, val)
+			if err != nil {
+				return nil, err
+			}
+			return reply.OK, nil
+		}
+
+		tran, err := c.begin(s)
+		if err != nil {
+			return nil, err
+		}
+
+		// Overwriting other types removes their elements too.
+		m, err := readMeta(tran, key)
+		if err == nil {
+			err = deleteKey(tran, key, m)
+		}
+		if err != nil && err != leveldb.ErrNotFound {
+			tran.Discard()
+			return nil, err
+		}
+		err = putMeta(tran, key, &metadata{typ: typeString, value: val})
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		tran.notify(lrdb.NotifyString, "set", key)
+		err = tran.Commit()
 		if err != nil {
 			return nil, err
 		}
@@ -51,15 +82,44 @@ func (c *LevelDB) set(name string, args []resp.Reply) (resp.Reply, error) {
 	}
 }
 
-func (c *LevelDB) mset(name string, args []resp.Reply) (resp.Reply, error) {
+// setBatch overwrites key with the string val by a batch,
+// the elements of the other types are removed in it too.
+func (c *LevelDB) setBatch(key, val []byte) error {
+	c.tranMu.RLock()
+	defer c.tranMu.RUnlock()
+
+	batch := &leveldb.Batch{}
+	w := batchWriter{c.db, batch}
+	m, err := readMeta(c.db, key)
+	if err == nil {
+		err = deleteKey(w, key, m)
+	}
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	// The flags of memcached are reset, the old ones are still seen by the reads of w.
+	err = putMetaFlags(w, key, &metadata{typ: typeString, value: val}, 0)
+	if err != nil {
+		return err
+	}
+	c.watcher.touch([][]byte{key})
+	err = c.db.Write(batch, nil)
+	if err != nil {
+		return err
+	}
+	c.notify(lrdb.NotifyString, "set", key)
+	return nil
+}
+
+func (c *LevelDB) mset(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	if len(args) == 0 || len(args)%2 != 0 {
 		return nil, engine.ErrWrongNumberOfArguments
 	}
 	if len(args) == 2 {
-		return c.set(name, args)
+		return c.set(s, name, args)
 	}
 
-	tran, err := c.db.OpenTransaction()
+	tran, err := c.begin(s)
 	if err != nil {
 		return nil, err
 	}
@@ -79,17 +139,27 @@ func (c *LevelDB) mset(name string, args []resp.Reply) (resp.Reply, error) {
 			return nil, err
 		}
 
-		err = tran.Put(key, val, nil)
+		m, err := readMeta(tran, key)
+		if err == nil {
+			err = deleteKey(tran, key, m)
+		}
+		if err != nil && err != leveldb.ErrNotFound {
+			tran.Discard()
+			return nil, err
+		}
+
+		err = putMeta(tran, key, &metadata{typ: typeString, value: val})
 		if err != nil {
 			tran.Discard()
 			return nil, err
 		}
+		tran.notify(lrdb.NotifyString, "set", key)
 	}
 	return reply.OK, nil
 
 }
 
-func (c *LevelDB) incr(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) incr(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -101,13 +171,13 @@ func (c *LevelDB) incr(name string, args []resp.Reply) (resp.Reply, error) {
 			return nil, err
 		}
 
-		tran, err := c.db.OpenTransaction()
+		tran, err := c.begin(s)
 		if err != nil {
 			return nil, err
 		}
 		defer tran.Commit()
 
-		val, err := tran.Get(key, nil)
+		val, m, err := getString(tran, key)
 		if err != nil {
 			tran.Discard()
 			return nil, err
@@ -119,16 +189,18 @@ func (c *LevelDB) incr(name string, args []resp.Reply) (resp.Reply, error) {
 			return nil, err
 		}
 
-		err = tran.Put(key, val, nil)
+		m.value = val
+		err = putMeta(tran, key, m)
 		if err != nil {
 			tran.Discard()
 			return nil, err
 		}
+		tran.notify(lrdb.NotifyString, "incrby", key)
 		return resp.ReplyInteger(val), nil
 	}
 }
 
-func (c *LevelDB) incrby(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) incrby(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -144,13 +216,13 @@ func (c *LevelDB) incrby(name string, args []resp.Reply) (resp.Reply, error) {
 			return nil, err
 		}
 
-		tran, err := c.db.OpenTransaction()
+		tran, err := c.begin(s)
 		if err != nil {
 			return nil, err
 		}
 		defer tran.Commit()
 
-		val, err := tran.Get(key, nil)
+		val, m, err := getString(tran, key)
 		if err != nil {
 			tran.Discard()
 			return nil, err
@@ -162,16 +234,18 @@ func (c *LevelDB) incrby(name string, args []resp.Reply) (resp.Reply, error) {
 			return nil, err
 		}
 
-		err = tran.Put(key, val, nil)
+		m.value = val
+		err = putMeta(tran, key, m)
 		if err != nil {
 			tran.Discard()
 			return nil, err
 		}
+		tran.notify(lrdb.NotifyString, "incrby", key)
 		return resp.ReplyInteger(val), nil
 	}
 }
 
-func (c *LevelDB) getset(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) getset(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -186,25 +260,36 @@ func (c *LevelDB) getset(name string, args []resp.Reply) (resp.Reply, error) {
 		if err != nil {
 			return nil, err
 		}
-		tran, err := c.db.OpenTransaction()
+		tran, err := c.begin(s)
 		if err != nil {
 			return nil, err
 		}
 		defer tran.Commit()
 
-		newVal, _ := tran.Get(key, nil)
+		m, err := getOrNewMeta(tran, key, typeString)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		oldVal := m.value
 
-		err = tran.Put(key, val, nil)
+		err = deleteKey(tran, key, m)
 		if err != nil {
 			tran.Discard()
 			return nil, err
 		}
+		err = putMeta(tran, key, &metadata{typ: typeString, value: val})
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		tran.notify(lrdb.NotifyString, "set", key)
 
-		return resp.ReplyBulk(newVal), nil
+		return resp.ReplyBulk(oldVal), nil
 	}
 }
 
-func (c *LevelDB) rename(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) rename(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -219,40 +304,51 @@ func (c *LevelDB) rename(name string, args []resp.Reply) (resp.Reply, error) {
 		if err != nil {
 			return nil, err
 		}
-		tran, err := c.db.OpenTransaction()
+		tran, err := c.begin(s)
 		if err != nil {
 			return nil, err
 		}
 		defer tran.Commit()
 
-		val, err := tran.Get(key, nil)
+		m, err := getMeta(tran, key)
 		if err != nil {
 			tran.Discard()
 			return nil, err
 		}
-		err = tran.Delete(key, nil)
-		if err != nil {
+		if bytes.Equal(key, newKey) {
+			return reply.OK, nil
+		}
+
+		old, err := readMeta(tran, newKey)
+		if err == nil {
+			err = deleteKey(tran, newKey, old)
+		}
+		if err != nil && err != leveldb.ErrNotFound {
 			tran.Discard()
 			return nil, err
 		}
-		err = tran.Put(newKey, val, nil)
+
+		err = renameKey(tran, key, newKey, m)
 		if err != nil {
 			tran.Discard()
 			return nil, err
 		}
+		tran.notify(lrdb.NotifyGeneric, "rename_from", key)
+		tran.notify(lrdb.NotifyGeneric, "rename_to", newKey)
 
 		return reply.OK, nil
 	}
 }
 
-func (c *LevelDB) del(name string, args []resp.Reply) (resp.Reply, error) {
-	tran, err := c.db.OpenTransaction()
+func (c *LevelDB) del(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	tran, err := c.begin(s)
 	if err != nil {
 		return nil, err
 	}
 	defer tran.Commit()
 
-	keys := make([][]byte, 0, len(args))
+	ts := now()
+	sum := 0
 	for _, arg := range args {
 		var key []byte
 		err := resp.ConvertFrom(arg, &key)
@@ -261,29 +357,32 @@ func (c *LevelDB) del(name string, args []resp.Reply) (resp.Reply, error) {
 			return nil, err
 		}
 
-		val, err := tran.Has(key, nil)
+		m, err := readMeta(tran, key)
 		if err != nil {
+			if err == leveldb.ErrNotFound {
+				continue
+			}
 			tran.Discard()
 			return nil, err
 		}
 
-		if val {
-			keys = append(keys, key)
-		}
-	}
-	for _, key := range keys {
-		err := tran.Delete(key, nil)
+		err = deleteKey(tran, key, m)
 		if err != nil {
 			tran.Discard()
 			return nil, err
 		}
+
+		if !m.expired(ts) {
+			tran.notify(lrdb.NotifyGeneric, "del", key)
+			sum++
+		}
 	}
 
-	return resp.ConvertTo(len(keys))
+	return resp.ConvertTo(sum)
 }
 
-func (c *LevelDB) exists(name string, args []resp.Reply) (resp.Reply, error) {
-	snap, err := c.db.GetSnapshot()
+func (c *LevelDB) exists(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	snap, err := c.snapshot(s)
 	if err != nil {
 		return nil, err
 	}
@@ -297,18 +396,19 @@ func (c *LevelDB) exists(name string, args []resp.Reply) (resp.Reply, error) {
 			return nil, err
 		}
 
-		val, err := snap.Has(key, nil)
+		_, err = getMeta(snap, key)
 		if err != nil {
+			if err == leveldb.ErrNotFound {
+				continue
+			}
 			return nil, err
 		}
-		if val {
-			sum++
-		}
+		sum++
 	}
 	return resp.ConvertTo(sum)
 }
 
-func (c *LevelDB) keys(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) keys(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 
 	switch len(args) {
 	default:
@@ -333,20 +433,20 @@ func (c *LevelDB) keys(name string, args []resp.Reply) (resp.Reply, error) {
 		return nil, err
 	}
 
-	urange := &util.Range{}
 	if len(start) != 0 {
-		urange.Start = bytesNext(start)
+		start = bytesNext(start)
 	}
 	if len(limit) != 0 {
-		urange.Limit = bytesNext(limit)
+		limit = bytesNext(limit)
 	}
+	urange := metaRange(start, limit)
 
 	multiBulk := resp.ReplyMultiBulk{}
 	if size == 0 {
 		return multiBulk, nil
 	}
 
-	snap, err := c.db.GetSnapshot()
+	snap, err := c.snapshot(s)
 	if err != nil {
 		return nil, err
 	}
@@ -355,16 +455,18 @@ func (c *LevelDB) keys(name string, args []resp.Reply) (resp.Reply, error) {
 	iter := snap.NewIterator(urange, nil)
 	defer iter.Release()
 
-	if !iter.First() {
-		return multiBulk, nil
-	}
-
-	for i := int64(0); i != size; i++ {
-		key := cloneBytes(iter.Key())
-		multiBulk = append(multiBulk, resp.ReplyBulk(key))
-		if !iter.Next() {
-			break
+	ts := now()
+	for i, ok := int64(0), iter.First(); ok && i != size; ok = iter.Next() {
+		m, err := decodeMeta(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		if m.expired(ts) {
+			continue
 		}
+		key := cloneBytes(decodeMetaKey(iter.Key()))
+		multiBulk = append(multiBulk, resp.ReplyBulk(key))
+		i++
 	}
 
 	if err := iter.Error(); err != nil {
@@ -374,7 +476,7 @@ func (c *LevelDB) keys(name string, args []resp.Reply) (resp.Reply, error) {
 	return multiBulk, nil
 }
 
-func (c *LevelDB) rkeys(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) rkeys(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 
 	switch len(args) {
 	default:
@@ -398,20 +500,14 @@ func (c *LevelDB) rkeys(name string, args []resp.Reply) (resp.Reply, error) {
 		return nil, err
 	}
 
-	urange := &util.Range{}
-	if len(start) != 0 {
-		urange.Start = start
-	}
-	if len(limit) != 0 {
-		urange.Limit = limit
-	}
+	urange := metaRange(start, limit)
 
 	multiBulk := resp.ReplyMultiBulk{}
 	if size == 0 {
 		return multiBulk, nil
 	}
 
-	snap, err := c.db.GetSnapshot()
+	snap, err := c.snapshot(s)
 	if err != nil {
 		return nil, err
 	}
@@ -420,16 +516,18 @@ func (c *LevelDB) rkeys(name string, args []resp.Reply) (resp.Reply, error) {
 	iter := snap.NewIterator(urange, nil)
 	defer iter.Release()
 
-	if !iter.Last() {
-		return multiBulk, nil
-	}
-
-	for i := int64(0); i != size; i++ {
-		key := cloneBytes(iter.Key())
-		multiBulk = append(multiBulk, resp.ReplyBulk(key))
-		if !iter.Prev() {
-			break
+	ts := now()
+	for i, ok := int64(0), iter.Last(); ok && i != size; ok = iter.Prev() {
+		m, err := decodeMeta(iter.Value())
+		if err != nil {
+			return nil, err
 		}
+		if m.expired(ts) {
+			continue
+		}
+		key := cloneBytes(decodeMetaKey(iter.Key()))
+		multiBulk = append(multiBulk, resp.ReplyBulk(key))
+		i++
 	}
 	if err := iter.Error(); err != nil {
 		return nil, err
@@ -438,7 +536,7 @@ func (c *LevelDB) rkeys(name string, args []resp.Reply) (resp.Reply, error) {
 	return multiBulk, nil
 }
 
-func (c *LevelDB) scan(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) scan(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 
 	switch len(args) {
 	default:
@@ -462,20 +560,20 @@ func (c *LevelDB) scan(name string, args []resp.Reply) (resp.Reply, error) {
 		return nil, err
 	}
 
-	urange := &util.Range{}
 	if len(start) != 0 {
-		urange.Start = bytesNext(start)
+		start = bytesNext(start)
 	}
 	if len(limit) != 0 {
-		urange.Limit = bytesNext(limit)
+		limit = bytesNext(limit)
 	}
+	urange := metaRange(start, limit)
 
 	multiBulk := resp.ReplyMultiBulk{}
 	if size == 0 {
-		return multiBulk, nil
+		return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
 	}
 
-	snap, err := c.db.GetSnapshot()
+	snap, err := c.snapshot(s)
 	if err != nil {
 		return nil, err
 	}
@@ -484,28 +582,30 @@ func (c *LevelDB) scan(name string, args []resp.Reply) (resp.Reply, error) {
 	iter := snap.NewIterator(urange, nil)
 	defer iter.Release()
 
-	if !iter.First() {
-		return multiBulk, nil
-	}
-
-	for i := int64(0); i != size; i++ {
-		data := cloneBytes(iter.Key())
+	ts := now()
+	for i, ok := int64(0), iter.First(); ok && i != size; ok = iter.Next() {
+		m, err := decodeMeta(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		if m.typ != typeString || m.expired(ts) {
+			continue
+		}
+		data := cloneBytes(decodeMetaKey(iter.Key()))
 		multiBulk = append(multiBulk, resp.ReplyBulk(data))
-		data = cloneBytes(iter.Value())
+		data = cloneBytes(m.value)
 		multiBulk = append(multiBulk, resp.ReplyBulk(data))
-		if !iter.Next() {
-			break
-		}
+		i++
 	}
 
 	if err := iter.Error(); err != nil {
 		return nil, err
 	}
 
-	return multiBulk, nil
+	return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
 }
 
-func (c *LevelDB) rscan(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) rscan(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 
 	switch len(args) {
 	default:
@@ -529,20 +629,14 @@ func (c *LevelDB) rscan(name string, args []resp.Reply) (resp.Reply, error) {
 		return nil, err
 	}
 
-	urange := &util.Range{}
-	if len(start) != 0 {
-		urange.Start = start
-	}
-	if len(limit) != 0 {
-		urange.Limit = limit
-	}
+	urange := metaRange(start, limit)
 
 	multiBulk := resp.ReplyMultiBulk{}
 	if size == 0 {
-		return multiBulk, nil
+		return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
 	}
 
-	snap, err := c.db.GetSnapshot()
+	snap, err := c.snapshot(s)
 	if err != nil {
 		return nil, err
 	}
@@ -551,27 +645,29 @@ func (c *LevelDB) rscan(name string, args []resp.Reply) (resp.Reply, error) {
 	iter := snap.NewIterator(urange, nil)
 	defer iter.Release()
 
-	if !iter.Last() {
-		return multiBulk, nil
-	}
-
-	for i := int64(0); i != size; i++ {
-		data := cloneBytes(iter.Key())
+	ts := now()
+	for i, ok := int64(0), iter.Last(); ok && i != size; ok = iter.Prev() {
+		m, err := decodeMeta(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		if m.typ != typeString || m.expired(ts) {
+			continue
+		}
+		data := cloneBytes(decodeMetaKey(iter.Key()))
 		multiBulk = append(multiBulk, resp.ReplyBulk(data))
-		data = cloneBytes(iter.Value())
+		data = cloneBytes(m.value)
 		multiBulk = append(multiBulk, resp.ReplyBulk(data))
-		if !iter.Prev() {
-			break
-		}
+		i++
 	}
 	if err := iter.Error(); err != nil {
 		return nil, err
 	}
 
-	return multiBulk, nil
+	return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
 }
 
-func (c *LevelDB) bitcount(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) bitcount(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 
 	start := int64(0)
 	end := int64(math.MaxInt64 - 1)
@@ -596,7 +692,7 @@ func (c *LevelDB) bitcount(name string, args []resp.Reply) (resp.Reply, error) {
 		return nil, err
 	}
 
-	val, err := c.db.Get(key, nil)
+	val, _, err := getString(c.reader(s), key)
 	if err != nil {
 		return nil, err
 	}
@@ -616,7 +712,7 @@ func (c *LevelDB) bitcount(name string, args []resp.Reply) (resp.Reply, error) {
 	return resp.ConvertTo(sum)
 }
 
-func (c *LevelDB) getbit(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) getbit(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -638,7 +734,7 @@ func (c *LevelDB) getbit(name string, args []resp.Reply) (resp.Reply, error) {
 	if offset < 0 {
 		return reply.Zero, nil
 	}
-	val, err := c.db.Get(key, nil)
+	val, _, err := getString(c.reader(s), key)
 	if err != nil {
 		return reply.Zero, nil
 	}
@@ -653,7 +749,7 @@ func (c *LevelDB) getbit(name string, args []resp.Reply) (resp.Reply, error) {
 	return reply.Zero, nil
 }
 
-func (c *LevelDB) setbit(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) setbit(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -682,26 +778,31 @@ func (c *LevelDB) setbit(name string, args []resp.Reply) (resp.Reply, error) {
 	}
 	newflage := flag != 0
 
-	tran, err := c.db.OpenTransaction()
+	tran, err := c.begin(s)
 	if err != nil {
 		return nil, err
 	}
 	defer tran.Commit()
 
-	val, _ := tran.Get(key, nil)
+	m, err := getOrNewMeta(tran, key, typeString)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
 
-	val, ok, err := engine.SetBit(val, offset, newflage)
+	val, ok, err := engine.SetBit(m.value, offset, newflage)
 	if err != nil {
 		tran.Discard()
 		return nil, err
 	}
 	if ok {
-		err = tran.Put(key, val, nil)
+		m.value = val
+		err = putMeta(tran, key, m)
 		if err != nil {
 			tran.Discard()
 			return nil, err
 		}
-
+		tran.notify(lrdb.NotifyString, "setbit", key)
 	}
 	if newflage {
 		return reply.Zero, nil
@@ -709,7 +810,7 @@ func (c *LevelDB) setbit(name string, args []resp.Reply) (resp.Reply, error) {
 	return reply.One, nil
 }
 
-func (c *LevelDB) append(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) append(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -728,24 +829,30 @@ func (c *LevelDB) append(name string, args []resp.Reply) (resp.Reply, error) {
 		return nil, err
 	}
 
-	tran, err := c.db.OpenTransaction()
+	tran, err := c.begin(s)
 	if err != nil {
 		return nil, err
 	}
 	defer tran.Commit()
 
-	val, _ := tran.Get(key, nil)
-	val = append(val, str...)
-	err = tran.Put(key, val, nil)
+	m, err := getOrNewMeta(tran, key, typeString)
 	if err != nil {
 		tran.Discard()
 		return nil, err
 	}
+	val := append(m.value, str...)
+	m.value = val
+	err = putMeta(tran, key, m)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	tran.notify(lrdb.NotifyString, "append", key)
 
 	return resp.ConvertTo(len(val))
 }
 
-func (c *LevelDB) strlen(name string, args []resp.Reply) (resp.Reply, error) {
+func (c *LevelDB) strlen(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
 	switch len(args) {
 	default:
 		return nil, engine.ErrWrongNumberOfArguments
@@ -757,6 +864,9 @@ func (c *LevelDB) strlen(name string, args []resp.Reply) (resp.Reply, error) {
 	if err != nil {
 		return nil, err
 	}
-	val, _ := c.db.Get(key, nil)
+	val, _, err := getString(c.reader(s), key)
+	if err == engine.ErrWrongType {
+		return nil, err
+	}
 	return resp.ConvertTo(len(val))
 }
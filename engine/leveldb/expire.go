@@ -0,0 +1,313 @@
+package leveldb
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+var (
+	ErrInvalidExpireTime = errors.New("Error invalid expire time")
+)
+
+const (
+	// reapInterval is how often the reaper looks for expired keys.
+	reapInterval = time.Second / 10
+	// reapBatch is the most keys deleted in one transaction,
+	// the reaper yields to the writers between batches.
+	reapBatch = 128
+)
+
+func (c *LevelDB) expire(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.expireBy(s, args, int64(time.Second/time.Millisecond))
+}
+
+func (c *LevelDB) pexpire(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.expireBy(s, args, 1)
+}
+
+func (c *LevelDB) expireBy(s *lrdb.Session, args []resp.Reply, unit int64) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 2:
+	}
+
+	var key []byte
+	var ttl int64
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &ttl)
+	if err != nil {
+		return nil, err
+	}
+	var expire int64
+	if ttl > 0 {
+		expire, err = expireAt(ttl, unit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getMeta(tran, key)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		tran.Discard()
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		err = deleteKey(tran, key, m)
+		tran.notify(lrdb.NotifyGeneric, "del", key)
+	} else {
+		err = setExpire(tran, key, m, expire)
+		tran.notify(lrdb.NotifyGeneric, "expire", key)
+	}
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	return reply.One, nil
+}
+
+// expireAt returns the time in milliseconds that is ttl units from now, ttl must be positive.
+func expireAt(ttl, unit int64) (int64, error) {
+	ts := now()
+	if ttl > (math.MaxInt64-ts)/unit {
+		return 0, ErrInvalidExpireTime
+	}
+	return ts + ttl*unit, nil
+}
+
+func (c *LevelDB) ttl(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.ttlBy(s, args, int64(time.Second/time.Millisecond))
+}
+
+func (c *LevelDB) pttl(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.ttlBy(s, args, 1)
+}
+
+func (c *LevelDB) ttlBy(s *lrdb.Session, args []resp.Reply, unit int64) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getMeta(c.reader(s), key)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return resp.ConvertTo(-2)
+		}
+		return nil, err
+	}
+	if m.expire == 0 {
+		return resp.ConvertTo(-1)
+	}
+
+	ttl := m.expire - now()
+	return resp.ConvertTo((ttl + unit/2) / unit)
+}
+
+func (c *LevelDB) persist(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getMeta(tran, key)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		tran.Discard()
+		return nil, err
+	}
+	if m.expire == 0 {
+		return reply.Zero, nil
+	}
+
+	err = setExpire(tran, key, m, 0)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	tran.notify(lrdb.NotifyGeneric, "persist", key)
+	return reply.One, nil
+}
+
+func (c *LevelDB) setex(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.setexBy(s, args, int64(time.Second/time.Millisecond))
+}
+
+func (c *LevelDB) psetex(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.setexBy(s, args, 1)
+}
+
+func (c *LevelDB) setexBy(s *lrdb.Session, args []resp.Reply, unit int64) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 3:
+	}
+
+	var key []byte
+	var ttl int64
+	var val []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &ttl)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, ErrInvalidExpireTime
+	}
+	expire, err := expireAt(ttl, unit)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[2], &val)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := readMeta(tran, key)
+	if err == nil {
+		err = deleteKey(tran, key, m)
+	}
+	if err != nil && err != leveldb.ErrNotFound {
+		tran.Discard()
+		return nil, err
+	}
+
+	err = putMeta(tran, key, &metadata{typ: typeString, expire: expire, value: val})
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	tran.notify(lrdb.NotifyString, "set", key)
+	tran.notify(lrdb.NotifyGeneric, "expire", key)
+	return reply.OK, nil
+}
+
+// reaper deletes the expired keys in the background until Close is called.
+func (c *LevelDB) reaper() {
+	defer close(c.reaperDone)
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closing:
+			return
+		case <-ticker.C:
+		}
+		for {
+			n, err := c.reap(reapBatch)
+			if err != nil || n < reapBatch {
+				break
+			}
+			select {
+			case <-c.closing:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// reap deletes at most max expired keys and returns the number of expiration entries processed.
+// The entries are only hints, the metadata of key is checked before it is deleted,
+// since a key may be overwritten or given a new time to live after the entry was written.
+func (c *LevelDB) reap(max int) (int, error) {
+	ts := now()
+	r := bytesPrefix([]byte{prefixExpire})
+	r.Limit = encodeExpireKey(ts+1, nil)
+
+	// Find the candidates first, so no transaction is held when there is nothing to do.
+	iter := c.db.NewIterator(r, nil)
+	entries := [][]byte{}
+	for len(entries) != max && iter.Next() {
+		entries = append(entries, cloneBytes(iter.Key()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	tran, err := c.begin(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		expire, key := decodeExpireKey(entry)
+		m, err := readMeta(tran, key)
+		if err != nil && err != leveldb.ErrNotFound {
+			tran.Discard()
+			return 0, err
+		}
+		if err == nil && m.expire == expire {
+			err = deleteKey(tran, key, m)
+			tran.notify(lrdb.NotifyExpired, "expired", key)
+		} else {
+			err = tran.Delete(entry, nil)
+		}
+		if err != nil {
+			tran.Discard()
+			return 0, err
+		}
+	}
+
+	err = tran.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,89 @@
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// formatKey is the record of the version of the layout, it's out of the ranges of the prefixes.
+var formatKey = []byte("\x00format")
+
+// The versions of the layout.
+const (
+	// formatRaw is the layout without a version, every key is a string stored as it is.
+	formatRaw = 0
+	// formatMeta is the layout of the prefixes, see prefixMeta.
+	formatMeta = 1
+)
+
+var ErrUnknownFormat = errors.New("Error unknown format of the database, it's written by a newer version")
+
+// checkFormat writes the version of the layout to a new database, and migrates a database of an old layout.
+func checkFormat(db *leveldb.DB) error {
+	data, err := db.Get(formatKey, nil)
+	if err == nil {
+		if !bytes.Equal(data, []byte{formatMeta}) {
+			return ErrUnknownFormat
+		}
+		return nil
+	}
+	if err != leveldb.ErrNotFound {
+		return err
+	}
+	return migrateRaw(db)
+}
+
+// migrateRaw moves the raw keys of formatRaw to metadata records of strings in a transaction,
+// so a database is either migrated as a whole or left as it is.
+func migrateRaw(db *leveldb.DB) error {
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+	tran, err := db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+
+	// All the raw keys are deleted before any record is written,
+	// since a record can have the same bytes as a raw key, like 'mfoo' of the key 'foo'.
+	iter := snap.NewIterator(nil, nil)
+	for iter.Next() {
+		err = tran.Delete(iter.Key(), nil)
+		if err != nil {
+			break
+		}
+	}
+	iter.Release()
+	if err == nil {
+		err = iter.Error()
+	}
+	if err != nil {
+		tran.Discard()
+		return fmt.Errorf("Error migrate the database: %s", err)
+	}
+
+	iter = snap.NewIterator(nil, nil)
+	for iter.Next() {
+		err = putMeta(tran, cloneBytes(iter.Key()), &metadata{typ: typeString, value: cloneBytes(iter.Value())})
+		if err != nil {
+			break
+		}
+	}
+	iter.Release()
+	if err == nil {
+		err = iter.Error()
+	}
+	if err == nil {
+		err = tran.Put(formatKey, []byte{formatMeta}, nil)
+	}
+	if err != nil {
+		tran.Discard()
+		return fmt.Errorf("Error migrate the database: %s", err)
+	}
+	return tran.Commit()
+}
@@ -0,0 +1,372 @@
+package leveldb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+func (c *LevelDB) hset(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getOrNewMeta(tran, key, typeHash)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+
+	added := int64(0)
+	for i := 1; i != len(args); i += 2 {
+		var field []byte
+		var val []byte
+		err = resp.ConvertFrom(args[i], &field)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		err = resp.ConvertFrom(args[i+1], &val)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+
+		sub := encodeSubKey(prefixHash, key, field)
+		_, err = tran.Get(sub, nil)
+		if err != nil {
+			if err != leveldb.ErrNotFound {
+				tran.Discard()
+				return nil, err
+			}
+			added++
+		}
+		err = tran.Put(sub, val, nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+	}
+
+	m.setCount(m.count() + added)
+	err = putMeta(tran, key, m)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	tran.notify(lrdb.NotifyHash, "hset", key)
+	return resp.ConvertTo(added)
+}
+
+func (c *LevelDB) hget(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 2:
+	}
+
+	var key []byte
+	var field []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &field)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	_, err = getTypedMeta(snap, key, typeHash)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return resp.ReplyBulk(nil), nil
+		}
+		return nil, err
+	}
+
+	val, err := snap.Get(encodeSubKey(prefixHash, key, field), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return resp.ReplyBulk(nil), nil
+		}
+		return nil, err
+	}
+	return resp.ReplyBulk(val), nil
+}
+
+func (c *LevelDB) hmget(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	multiBulk := make(resp.ReplyMultiBulk, 0, len(args)-1)
+	_, err = getTypedMeta(snap, key, typeHash)
+	if err != nil {
+		if err != leveldb.ErrNotFound {
+			return nil, err
+		}
+		for range args[1:] {
+			multiBulk = append(multiBulk, resp.ReplyBulk(nil))
+		}
+		return multiBulk, nil
+	}
+
+	for _, arg := range args[1:] {
+		var field []byte
+		err = resp.ConvertFrom(arg, &field)
+		if err != nil {
+			return nil, err
+		}
+		val, err := snap.Get(encodeSubKey(prefixHash, key, field), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			return nil, err
+		}
+		multiBulk = append(multiBulk, resp.ReplyBulk(val))
+	}
+	return multiBulk, nil
+}
+
+func (c *LevelDB) hgetall(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	multiBulk := resp.ReplyMultiBulk{}
+	_, err = getTypedMeta(snap, key, typeHash)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
+		}
+		return nil, err
+	}
+
+	iter := snap.NewIterator(subRange(prefixHash, key, nil, nil), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		data := cloneBytes(decodeSubKey(iter.Key()))
+		multiBulk = append(multiBulk, resp.ReplyBulk(data))
+		data = cloneBytes(iter.Value())
+		multiBulk = append(multiBulk, resp.ReplyBulk(data))
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
+}
+
+func (c *LevelDB) hdel(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getTypedMeta(tran, key, typeHash)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		tran.Discard()
+		return nil, err
+	}
+
+	removed := int64(0)
+	for _, arg := range args[1:] {
+		var field []byte
+		err = resp.ConvertFrom(arg, &field)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+
+		sub := encodeSubKey(prefixHash, key, field)
+		_, err = tran.Get(sub, nil)
+		if err != nil {
+			if err == leveldb.ErrNotFound {
+				continue
+			}
+			tran.Discard()
+			return nil, err
+		}
+		err = tran.Delete(sub, nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		removed++
+	}
+
+	m.setCount(m.count() - removed)
+	if m.count() <= 0 {
+		err = deleteKey(tran, key, m)
+	} else {
+		err = putMeta(tran, key, m)
+	}
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	if removed != 0 {
+		tran.notify(lrdb.NotifyHash, "hdel", key)
+	}
+	if m.count() <= 0 {
+		tran.notify(lrdb.NotifyGeneric, "del", key)
+	}
+	return resp.ConvertTo(removed)
+}
+
+func (c *LevelDB) hlen(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getTypedMeta(c.reader(s), key, typeHash)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		return nil, err
+	}
+	return resp.ConvertTo(m.count())
+}
+
+func (c *LevelDB) hscan(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 4:
+	}
+	var key []byte
+	var start []byte
+	var limit []byte
+	var size int64
+
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &start)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[2], &limit)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[3], &size)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(start) != 0 {
+		start = bytesNext(start)
+	}
+	if len(limit) != 0 {
+		limit = bytesNext(limit)
+	}
+	urange := subRange(prefixHash, key, start, limit)
+
+	multiBulk := resp.ReplyMultiBulk{}
+	if size == 0 {
+		return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	_, err = getTypedMeta(snap, key, typeHash)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
+		}
+		return nil, err
+	}
+
+	iter := snap.NewIterator(urange, nil)
+	defer iter.Release()
+
+	for i, ok := int64(0), iter.First(); ok && i != size; ok = iter.Next() {
+		data := cloneBytes(decodeSubKey(iter.Key()))
+		multiBulk = append(multiBulk, resp.ReplyBulk(data))
+		data = cloneBytes(iter.Value())
+		multiBulk = append(multiBulk, resp.ReplyBulk(data))
+		i++
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return lrdb.Map{ReplyMultiBulk: multiBulk}, nil
+}
@@ -1,31 +1,60 @@
 package leveldb
 
 import (
+	"sync"
+
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/wzshiming/lrdb"
 	"github.com/wzshiming/lrdb/engine"
 )
 
 type LevelDB struct {
 	db *leveldb.DB
+	// tranMu is held by a transaction, and read-held by a batch,
+	// so the records that a batch is based on are not changed by a transaction until it's written.
+	tranMu     sync.RWMutex
+	closing    chan struct{}
+	reaperDone chan struct{}
+	watcher    *watcher
+	notifier   lrdb.Notifier
 }
 
 func NewLevelDB(path string) (*LevelDB, error) {
+	return NewLevelDBWithOptions(path, nil)
+}
+
+// NewLevelDBWithOptions opens the database at path with the options of LevelDB, like the cache size and the compression.
+func NewLevelDBWithOptions(path string, o *opt.Options) (*LevelDB, error) {
 	s, err := storage.OpenFile(path, false)
 	if err != nil {
 		return nil, err
 	}
-	return NewLevelDBWith(s)
+	return newLevelDB(s, o)
 }
 
 func NewLevelDBWith(s storage.Storage) (*LevelDB, error) {
-	db, err := leveldb.Recover(s, nil)
+	return newLevelDB(s, nil)
+}
+
+func newLevelDB(s storage.Storage, o *opt.Options) (*LevelDB, error) {
+	db, err := leveldb.Recover(s, o)
 	if err != nil {
 		return nil, err
 	}
+	err = checkFormat(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 	c := &LevelDB{
-		db: db,
+		db:         db,
+		closing:    make(chan struct{}),
+		reaperDone: make(chan struct{}),
+		watcher:    newWatcher(),
 	}
+	go c.reaper()
 	return c, nil
 }
 
@@ -34,10 +63,35 @@ func NewLevelDBWithMemStorage() (*LevelDB, error) {
 	return NewLevelDBWith(s)
 }
 
+// SetNotifier sets the notifier of keyspace events, it should be set before any command.
+func (c *LevelDB) SetNotifier(n lrdb.Notifier) {
+	c.notifier = n
+}
+
+// notify notifies a keyspace event of a write that is not in a transaction.
+func (c *LevelDB) notify(class int, name string, key []byte) {
+	if c.notifier == nil {
+		return
+	}
+	c.notifier.Notify(class, name, key)
+}
+
+// Close stops the background reaper and closes the database.
+func (c *LevelDB) Close() error {
+	close(c.closing)
+	<-c.reaperDone
+	return c.db.Close()
+}
+
 func (c *LevelDB) Cmd() *engine.Commands {
 	commands := engine.NewCommands(nil)
+	commands.SetTransactor(c.transactor)
+	commands.SetCloser(c)
+	commands.SetStater(c)
+	commands.Use(c.rollback)
 
-	commands.AddCommand("info", c.info)
+	commands.AddCommand("watch", c.watch)
+	commands.AddCommand("unwatch", c.unwatch)
 
 	commands.AddCommand("getbit", c.getbit)
 	commands.AddCommand("setbit", c.setbit)
@@ -56,6 +110,55 @@ func (c *LevelDB) Cmd() *engine.Commands {
 	commands.AddCommand("incr", c.incr)
 	commands.AddCommand("incrby", c.incrby)
 
+	commands.AddCommand("expire", c.expire)
+	commands.AddCommand("pexpire", c.pexpire)
+	commands.AddCommand("ttl", c.ttl)
+	commands.AddCommand("pttl", c.pttl)
+	commands.AddCommand("persist", c.persist)
+	commands.AddCommand("setex", c.setex)
+	commands.AddCommand("psetex", c.psetex)
+
+	commands.AddCommand("hset", c.hset)
+	commands.AddCommand("hget", c.hget)
+	commands.AddCommand("hmget", c.hmget)
+	commands.AddCommand("hgetall", c.hgetall)
+	commands.AddCommand("hdel", c.hdel)
+	commands.AddCommand("hlen", c.hlen)
+	commands.AddCommand("hscan", c.hscan)
+
+	commands.AddCommand("lpush", c.lpush)
+	commands.AddCommand("rpush", c.rpush)
+	commands.AddCommand("lpop", c.lpop)
+	commands.AddCommand("rpop", c.rpop)
+	commands.AddCommand("llen", c.llen)
+	commands.AddCommand("lindex", c.lindex)
+	commands.AddCommand("lrange", c.lrange)
+	commands.AddCommand("ltrim", c.ltrim)
+
+	commands.AddCommand("zadd", c.zadd)
+	commands.AddCommand("zincrby", c.zincrby)
+	commands.AddCommand("zrem", c.zrem)
+	commands.AddCommand("zcard", c.zcard)
+	commands.AddCommand("zscore", c.zscore)
+	commands.AddCommand("zrank", c.zrank)
+	commands.AddCommand("zrevrank", c.zrevrank)
+	commands.AddCommand("zrange", c.zrange)
+	commands.AddCommand("zrevrange", c.zrevrange)
+	commands.AddCommand("zrangebyscore", c.zrangebyscore)
+	commands.AddCommand("zrevrangebyscore", c.zrevrangebyscore)
+
+	commands.AddCommand("sadd", c.sadd)
+	commands.AddCommand("srem", c.srem)
+	commands.AddCommand("sismember", c.sismember)
+	commands.AddCommand("scard", c.scard)
+	commands.AddCommand("smembers", c.smembers)
+	commands.AddCommand("sinter", c.sinter)
+	commands.AddCommand("sunion", c.sunion)
+	commands.AddCommand("sdiff", c.sdiff)
+	commands.AddCommand("sinterstore", c.sinterstore)
+	commands.AddCommand("sunionstore", c.sunionstore)
+	commands.AddCommand("sdiffstore", c.sdiffstore)
+
 	commands.AddCommand("keys", c.keys)
 	commands.AddCommand("rkeys", c.rkeys)
 	commands.AddCommand("scan", c.scan)
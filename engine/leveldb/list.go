@@ -0,0 +1,413 @@
+package leveldb
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+// The elements of a list are stored under sequence numbers in [head, tail),
+// pushing to the left decreases head and pushing to the right increases tail.
+
+// encodeSeq returns the order preserving bytes of a sequence number.
+func encodeSeq(seq int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(seq)^1<<63)
+	return buf
+}
+
+// listRange returns the head and tail of a list.
+func (m *metadata) listRange() (int64, int64) {
+	if len(m.value) < 16 {
+		return 0, 0
+	}
+	head := int64(binary.BigEndian.Uint64(m.value))
+	tail := int64(binary.BigEndian.Uint64(m.value[8:]))
+	return head, tail
+}
+
+func (m *metadata) setListRange(head, tail int64) {
+	m.value = make([]byte, 16)
+	binary.BigEndian.PutUint64(m.value, uint64(head))
+	binary.BigEndian.PutUint64(m.value[8:], uint64(tail))
+}
+
+// listOffsets converts start and stop that may count from the end with negative numbers
+// to the offsets from the head, it returns false if the range is empty.
+func listOffsets(start, stop, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop || start >= size {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+func (c *LevelDB) lpush(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.push(s, args, true)
+}
+
+func (c *LevelDB) rpush(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.push(s, args, false)
+}
+
+func (c *LevelDB) push(s *lrdb.Session, args []resp.Reply, left bool) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getOrNewMeta(tran, key, typeList)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+
+	head, tail := m.listRange()
+	for _, arg := range args[1:] {
+		var val []byte
+		err = resp.ConvertFrom(arg, &val)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+
+		var seq int64
+		if left {
+			head--
+			seq = head
+		} else {
+			seq = tail
+			tail++
+		}
+		err = tran.Put(encodeSubKey(prefixList, key, encodeSeq(seq)), val, nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+	}
+
+	m.setListRange(head, tail)
+	err = putMeta(tran, key, m)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	if left {
+		tran.notify(lrdb.NotifyList, "lpush", key)
+	} else {
+		tran.notify(lrdb.NotifyList, "rpush", key)
+	}
+	return resp.ConvertTo(tail - head)
+}
+
+func (c *LevelDB) lpop(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.pop(s, args, true)
+}
+
+func (c *LevelDB) rpop(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.pop(s, args, false)
+}
+
+func (c *LevelDB) pop(s *lrdb.Session, args []resp.Reply, left bool) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getTypedMeta(tran, key, typeList)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return resp.ReplyBulk(nil), nil
+		}
+		tran.Discard()
+		return nil, err
+	}
+
+	head, tail := m.listRange()
+	var seq int64
+	if left {
+		seq = head
+		head++
+	} else {
+		tail--
+		seq = tail
+	}
+
+	sub := encodeSubKey(prefixList, key, encodeSeq(seq))
+	val, err := tran.Get(sub, nil)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	err = tran.Delete(sub, nil)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+
+	if head == tail {
+		err = deleteKey(tran, key, m)
+	} else {
+		m.setListRange(head, tail)
+		err = putMeta(tran, key, m)
+	}
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	if left {
+		tran.notify(lrdb.NotifyList, "lpop", key)
+	} else {
+		tran.notify(lrdb.NotifyList, "rpop", key)
+	}
+	if head == tail {
+		tran.notify(lrdb.NotifyGeneric, "del", key)
+	}
+	return resp.ReplyBulk(val), nil
+}
+
+func (c *LevelDB) llen(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getTypedMeta(c.reader(s), key, typeList)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		return nil, err
+	}
+	head, tail := m.listRange()
+	return resp.ConvertTo(tail - head)
+}
+
+func (c *LevelDB) lindex(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 2:
+	}
+
+	var key []byte
+	var index int64
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &index)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	m, err := getTypedMeta(snap, key, typeList)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return resp.ReplyBulk(nil), nil
+		}
+		return nil, err
+	}
+
+	head, tail := m.listRange()
+	if index < 0 {
+		index += tail - head
+	}
+	if index < 0 || index >= tail-head {
+		return resp.ReplyBulk(nil), nil
+	}
+
+	val, err := snap.Get(encodeSubKey(prefixList, key, encodeSeq(head+index)), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.ReplyBulk(val), nil
+}
+
+func (c *LevelDB) lrange(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 3:
+	}
+
+	var key []byte
+	var start int64
+	var stop int64
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &start)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[2], &stop)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	multiBulk := resp.ReplyMultiBulk{}
+	m, err := getTypedMeta(snap, key, typeList)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return multiBulk, nil
+		}
+		return nil, err
+	}
+
+	head, tail := m.listRange()
+	start, stop, ok := listOffsets(start, stop, tail-head)
+	if !ok {
+		return multiBulk, nil
+	}
+
+	urange := subRange(prefixList, key, encodeSeq(head+start), encodeSeq(head+stop+1))
+	iter := snap.NewIterator(urange, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		data := cloneBytes(iter.Value())
+		multiBulk = append(multiBulk, resp.ReplyBulk(data))
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return multiBulk, nil
+}
+
+func (c *LevelDB) ltrim(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 3:
+	}
+
+	var key []byte
+	var start int64
+	var stop int64
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &start)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[2], &stop)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getTypedMeta(tran, key, typeList)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.OK, nil
+		}
+		tran.Discard()
+		return nil, err
+	}
+
+	head, tail := m.listRange()
+	start, stop, ok := listOffsets(start, stop, tail-head)
+	if !ok {
+		err = deleteKey(tran, key, m)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		tran.notify(lrdb.NotifyList, "ltrim", key)
+		tran.notify(lrdb.NotifyGeneric, "del", key)
+		return reply.OK, nil
+	}
+
+	for seq := head; seq != head+start; seq++ {
+		err = tran.Delete(encodeSubKey(prefixList, key, encodeSeq(seq)), nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+	}
+	for seq := head + stop + 1; seq != tail; seq++ {
+		err = tran.Delete(encodeSubKey(prefixList, key, encodeSeq(seq)), nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+	}
+
+	m.setListRange(head+start, head+stop+1)
+	err = putMeta(tran, key, m)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	tran.notify(lrdb.NotifyList, "ltrim", key)
+	return reply.OK, nil
+}
@@ -0,0 +1,227 @@
+package leveldb
+
+import (
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
+)
+
+// Memcached returns the storage of the memcached protocol, the items are the strings,
+// whose flags and cas unique are kept in the version records.
+// The keys of the other types are skipped by Get, and overwritten by set.
+func (c *LevelDB) Memcached() lrdb.MemcachedStore {
+	return memcached{c}
+}
+
+type memcached struct {
+	c *LevelDB
+}
+
+func (m memcached) Get(keys ...[]byte) ([]*lrdb.MemcachedItem, error) {
+	snap, err := m.c.snapshot(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	items := []*lrdb.MemcachedItem{}
+	for _, key := range keys {
+		val, meta, err := getString(snap, key)
+		if err != nil {
+			if err == leveldb.ErrNotFound || err == engine.ErrWrongType {
+				continue
+			}
+			return nil, err
+		}
+		version, flags, err := getVersion(snap, key)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &lrdb.MemcachedItem{
+			Key:    key,
+			Value:  cloneBytes(val),
+			Flags:  flags,
+			Expire: meta.expire,
+			CAS:    version,
+		})
+	}
+	return items, nil
+}
+
+func (m memcached) Store(mode string, item *lrdb.MemcachedItem) error {
+	tran, err := m.c.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer tran.Commit()
+
+	key := item.Key
+	old, err := readMeta(tran, key)
+	if err != nil && err != leveldb.ErrNotFound {
+		tran.Discard()
+		return err
+	}
+	exists := err == nil && !old.expired(now())
+	var version uint64
+	var flags uint32
+	if exists && old.typ == typeString {
+		version, flags, err = getVersion(tran, key)
+		if err != nil {
+			tran.Discard()
+			return err
+		}
+	}
+
+	val := item.Value
+	expire := item.Expire
+	event := "set"
+	newFlags := item.Flags
+	switch mode {
+	default:
+		tran.Discard()
+		return engine.ErrSyntax
+	case lrdb.MemcachedSet:
+	case lrdb.MemcachedAdd:
+		if exists {
+			tran.Discard()
+			return lrdb.ErrNotStored
+		}
+	case lrdb.MemcachedReplace:
+		if !exists {
+			tran.Discard()
+			return lrdb.ErrNotStored
+		}
+	case lrdb.MemcachedCAS:
+		if !exists {
+			tran.Discard()
+			return lrdb.ErrNotFound
+		}
+		if old.typ != typeString || version != item.CAS {
+			tran.Discard()
+			return lrdb.ErrExists
+		}
+	case lrdb.MemcachedAppend, lrdb.MemcachedPrepend:
+		if !exists || old.typ != typeString {
+			tran.Discard()
+			return lrdb.ErrNotStored
+		}
+		if mode == lrdb.MemcachedAppend {
+			val = append(cloneBytes(old.value), val...)
+		} else {
+			val = append(cloneBytes(val), old.value...)
+		}
+		expire = old.expire
+		newFlags = flags
+		event = "append"
+	}
+
+	if old != nil {
+		err = deleteKey(tran, key, old)
+		if err != nil {
+			tran.Discard()
+			return err
+		}
+	}
+	err = putMetaFlags(tran, key, &metadata{typ: typeString, expire: expire, value: val}, newFlags)
+	if err != nil {
+		tran.Discard()
+		return err
+	}
+	tran.notify(lrdb.NotifyString, event, key)
+	if expire != 0 && event == "set" {
+		tran.notify(lrdb.NotifyGeneric, "expire", key)
+	}
+	return nil
+}
+
+func (m memcached) Delete(key []byte) error {
+	tran, err := m.c.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer tran.Commit()
+
+	meta, err := getMeta(tran, key)
+	if err != nil {
+		tran.Discard()
+		if err == leveldb.ErrNotFound {
+			return lrdb.ErrNotFound
+		}
+		return err
+	}
+	err = deleteKey(tran, key, meta)
+	if err != nil {
+		tran.Discard()
+		return err
+	}
+	tran.notify(lrdb.NotifyGeneric, "del", key)
+	return nil
+}
+
+func (m memcached) Incr(key []byte, delta uint64, decr bool) (uint64, error) {
+	tran, err := m.c.begin(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tran.Commit()
+
+	val, meta, err := getString(tran, key)
+	if err != nil {
+		tran.Discard()
+		if err == leveldb.ErrNotFound {
+			return 0, lrdb.ErrNotFound
+		}
+		return 0, err
+	}
+	n, err := strconv.ParseUint(string(val), 10, 64)
+	if err != nil {
+		tran.Discard()
+		return 0, lrdb.ErrNonNumeric
+	}
+	event := "incrby"
+	if decr {
+		event = "decrby"
+		if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+	} else {
+		n += delta
+	}
+
+	meta.value = strconv.AppendUint(nil, n, 10)
+	err = putMeta(tran, key, meta)
+	if err != nil {
+		tran.Discard()
+		return 0, err
+	}
+	tran.notify(lrdb.NotifyString, event, key)
+	return n, nil
+}
+
+func (m memcached) Touch(key []byte, expire int64) error {
+	tran, err := m.c.begin(nil)
+	if err != nil {
+		return err
+	}
+	defer tran.Commit()
+
+	meta, err := getMeta(tran, key)
+	if err != nil {
+		tran.Discard()
+		if err == leveldb.ErrNotFound {
+			return lrdb.ErrNotFound
+		}
+		return err
+	}
+	err = setExpire(tran, key, meta, expire)
+	if err != nil {
+		tran.Discard()
+		return err
+	}
+	tran.notify(lrdb.NotifyGeneric, "expire", key)
+	return nil
+}
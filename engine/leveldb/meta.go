@@ -0,0 +1,472 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/wzshiming/lrdb/engine"
+)
+
+// Every user key is stored as a metadata record under prefixMeta.
+// Keys with a time to live also get an entry under prefixExpire,
+// ordered by the expiration time, so the reaper can find them in order.
+// The elements of the other types are stored as sub keys,
+// see encodeSubKey.
+// Strings also get a record under prefixVersion, see putVersion.
+const (
+	prefixMeta    = 'm'
+	prefixExpire  = 'x'
+	prefixVersion = 'v'
+	prefixHash    = 'h'
+	prefixList    = 'l'
+	prefixZSet    = 'z'
+	prefixZScore  = 'Z'
+	prefixSet     = 'S'
+)
+
+const (
+	typeString = 's'
+	typeHash   = 'h'
+	typeList   = 'l'
+	typeZSet   = 'z'
+	typeSet    = 'S'
+)
+
+// subPrefixes returns the prefixes of sub keys belonging to a key of typ.
+func subPrefixes(typ byte) []byte {
+	switch typ {
+	case typeHash:
+		return []byte{prefixHash}
+	case typeList:
+		return []byte{prefixList}
+	case typeZSet:
+		return []byte{prefixZSet, prefixZScore}
+	case typeSet:
+		return []byte{prefixSet}
+	}
+	return nil
+}
+
+var (
+	ErrCorruptedMeta = errors.New("Error corrupted metadata")
+)
+
+// metaHeaderSize is the size of type and expire fields in front of the value.
+const metaHeaderSize = 1 + 8
+
+type writer interface {
+	leveldb.Reader
+	Put(key, value []byte, wo *opt.WriteOptions) error
+	Delete(key []byte, wo *opt.WriteOptions) error
+}
+
+// batchWriter writes to a batch, its reads don't see the writes of the batch.
+type batchWriter struct {
+	leveldb.Reader
+	batch *leveldb.Batch
+}
+
+func (w batchWriter) Put(key, value []byte, wo *opt.WriteOptions) error {
+	w.batch.Put(key, value)
+	return nil
+}
+
+func (w batchWriter) Delete(key []byte, wo *opt.WriteOptions) error {
+	w.batch.Delete(key)
+	return nil
+}
+
+// metadata is the decoded record of a key.
+type metadata struct {
+	typ    byte
+	expire int64 // Unix milliseconds, 0 means the key never expires
+	value  []byte
+}
+
+func (m *metadata) expired(now int64) bool {
+	return m.expire != 0 && m.expire <= now
+}
+
+func (m *metadata) encode() []byte {
+	buf := make([]byte, metaHeaderSize+len(m.value))
+	buf[0] = m.typ
+	binary.BigEndian.PutUint64(buf[1:], uint64(m.expire))
+	copy(buf[metaHeaderSize:], m.value)
+	return buf
+}
+
+func decodeMeta(data []byte) (*metadata, error) {
+	if len(data) < metaHeaderSize {
+		return nil, ErrCorruptedMeta
+	}
+	return &metadata{
+		typ:    data[0],
+		expire: int64(binary.BigEndian.Uint64(data[1:])),
+		value:  data[metaHeaderSize:],
+	}, nil
+}
+
+func encodeMetaKey(key []byte) []byte {
+	buf := make([]byte, 1+len(key))
+	buf[0] = prefixMeta
+	copy(buf[1:], key)
+	return buf
+}
+
+func decodeMetaKey(data []byte) []byte {
+	return data[1:]
+}
+
+func encodeExpireKey(expire int64, key []byte) []byte {
+	buf := make([]byte, 1+8+len(key))
+	buf[0] = prefixExpire
+	binary.BigEndian.PutUint64(buf[1:], uint64(expire))
+	copy(buf[9:], key)
+	return buf
+}
+
+func decodeExpireKey(data []byte) (int64, []byte) {
+	return int64(binary.BigEndian.Uint64(data[1:])), data[9:]
+}
+
+func encodeVersionKey(key []byte) []byte {
+	buf := make([]byte, 1+len(key))
+	buf[0] = prefixVersion
+	copy(buf[1:], key)
+	return buf
+}
+
+// lastVersion is the last version given to a write.
+var lastVersion uint64
+
+// nextVersion returns a version greater than the given ones,
+// it's taken from the clock, so it keeps growing after a restart.
+func nextVersion() uint64 {
+	for {
+		last := atomic.LoadUint64(&lastVersion)
+		next := uint64(time.Now().UnixNano())
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&lastVersion, last, next) {
+			return next
+		}
+	}
+}
+
+// putVersion gives the string key a new version, which is the cas unique of memcached,
+// along with the flags of memcached, every write of a string changes its version.
+func putVersion(w writer, key []byte, flags uint32) error {
+	buf := make([]byte, 8+4)
+	binary.BigEndian.PutUint64(buf, nextVersion())
+	binary.BigEndian.PutUint32(buf[8:], flags)
+	return w.Put(encodeVersionKey(key), buf, nil)
+}
+
+// getVersion returns the version and the flags of the string key,
+// both are 0 for a string written before the versions were kept.
+func getVersion(r leveldb.Reader, key []byte) (uint64, uint32, error) {
+	data, err := r.Get(encodeVersionKey(key), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	if len(data) != 8+4 {
+		return 0, 0, ErrCorruptedMeta
+	}
+	return binary.BigEndian.Uint64(data), binary.BigEndian.Uint32(data[8:]), nil
+}
+
+// encodeSubKey returns the key of an element of key.
+// The length of key is written in front of it,
+// so the elements of a key never mix with another key that it is a prefix of.
+func encodeSubKey(prefix byte, key, sub []byte) []byte {
+	buf := make([]byte, 1+4+len(key)+len(sub))
+	buf[0] = prefix
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(key)))
+	copy(buf[5:], key)
+	copy(buf[5+len(key):], sub)
+	return buf
+}
+
+func decodeSubKey(data []byte) []byte {
+	size := binary.BigEndian.Uint32(data[1:])
+	return data[5+size:]
+}
+
+// subRange returns the range of the elements of key in [start, limit).
+// Empty start or limit means no bound on that side.
+func subRange(prefix byte, key, start, limit []byte) *util.Range {
+	r := bytesPrefix(encodeSubKey(prefix, key, nil))
+	if len(start) != 0 {
+		r.Start = encodeSubKey(prefix, key, start)
+	}
+	if len(limit) != 0 {
+		r.Limit = encodeSubKey(prefix, key, limit)
+	}
+	return r
+}
+
+// count returns the number of elements of a container key.
+func (m *metadata) count() int64 {
+	if len(m.value) < 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(m.value))
+}
+
+func (m *metadata) setCount(n int64) {
+	m.value = make([]byte, 8)
+	binary.BigEndian.PutUint64(m.value, uint64(n))
+}
+
+// metaRange returns the range of metadata records for user keys in [start, limit).
+// Empty start or limit means no bound on that side.
+func metaRange(start, limit []byte) *util.Range {
+	r := bytesPrefix([]byte{prefixMeta})
+	if len(start) != 0 {
+		r.Start = encodeMetaKey(start)
+	}
+	if len(limit) != 0 {
+		r.Limit = encodeMetaKey(limit)
+	}
+	return r
+}
+
+// now returns the current time in Unix milliseconds.
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// readMeta returns the metadata of key, including the expired one.
+func readMeta(r leveldb.Reader, key []byte) (*metadata, error) {
+	data, err := r.Get(encodeMetaKey(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMeta(data)
+}
+
+// getMeta returns the metadata of key, an expired key is reported as not found.
+func getMeta(r leveldb.Reader, key []byte) (*metadata, error) {
+	m, err := readMeta(r, key)
+	if err != nil {
+		return nil, err
+	}
+	if m.expired(now()) {
+		return nil, leveldb.ErrNotFound
+	}
+	return m, nil
+}
+
+// getTypedMeta is like getMeta but the key must be of typ.
+func getTypedMeta(r leveldb.Reader, key []byte, typ byte) (*metadata, error) {
+	m, err := getMeta(r, key)
+	if err != nil {
+		return nil, err
+	}
+	if m.typ != typ {
+		return nil, engine.ErrWrongType
+	}
+	return m, nil
+}
+
+// getString returns the value of a string key.
+func getString(r leveldb.Reader, key []byte) ([]byte, *metadata, error) {
+	m, err := getTypedMeta(r, key, typeString)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.value, m, nil
+}
+
+// putMeta writes the metadata of key and its expiration index,
+// a string keeps the flags of memcached it has, they are reset only when the key is deleted first.
+func putMeta(w writer, key []byte, m *metadata) error {
+	var flags uint32
+	if m.typ == typeString {
+		_, f, err := getVersion(w, key)
+		if err != nil {
+			return err
+		}
+		flags = f
+	}
+	return putMetaFlags(w, key, m, flags)
+}
+
+// putMetaFlags is like putMeta, but a string gets flags.
+func putMetaFlags(w writer, key []byte, m *metadata, flags uint32) error {
+	err := w.Put(encodeMetaKey(key), m.encode(), nil)
+	if err != nil {
+		return err
+	}
+	if m.expire != 0 {
+		err = w.Put(encodeExpireKey(m.expire, key), nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if m.typ == typeString {
+		return putVersion(w, key, flags)
+	}
+	return nil
+}
+
+// deleteKey removes key and everything belonging to it.
+func deleteKey(w writer, key []byte, m *metadata) error {
+	err := w.Delete(encodeMetaKey(key), nil)
+	if err != nil {
+		return err
+	}
+	if m.expire != 0 {
+		err = w.Delete(encodeExpireKey(m.expire, key), nil)
+		if err != nil {
+			return err
+		}
+	}
+	if m.typ == typeString {
+		err = w.Delete(encodeVersionKey(key), nil)
+		if err != nil {
+			return err
+		}
+	}
+	for _, prefix := range subPrefixes(m.typ) {
+		err = deleteSubKeys(w, prefix, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSubKeys removes the elements of key under prefix.
+func deleteSubKeys(w writer, prefix byte, key []byte) error {
+	iter := w.NewIterator(subRange(prefix, key, nil, nil), nil)
+	subs := [][]byte{}
+	for iter.Next() {
+		subs = append(subs, cloneBytes(iter.Key()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		err := w.Delete(sub, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameKey moves key and everything belonging to it to newKey,
+// newKey must not exist.
+func renameKey(w writer, key, newKey []byte, m *metadata) error {
+	for _, prefix := range subPrefixes(m.typ) {
+		iter := w.NewIterator(subRange(prefix, key, nil, nil), nil)
+		subs := [][]byte{}
+		vals := [][]byte{}
+		for iter.Next() {
+			subs = append(subs, cloneBytes(iter.Key()))
+			vals = append(vals, cloneBytes(iter.Value()))
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+		for i, sub := range subs {
+			err := w.Delete(sub, nil)
+			if err != nil {
+				return err
+			}
+			err = w.Put(encodeSubKey(prefix, newKey, decodeSubKey(sub)), vals[i], nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var flags uint32
+	if m.typ == typeString {
+		_, f, err := getVersion(w, key)
+		if err != nil {
+			return err
+		}
+		flags = f
+	}
+	err := w.Delete(encodeMetaKey(key), nil)
+	if err != nil {
+		return err
+	}
+	if m.expire != 0 {
+		err = w.Delete(encodeExpireKey(m.expire, key), nil)
+		if err != nil {
+			return err
+		}
+	}
+	if m.typ == typeString {
+		err = w.Delete(encodeVersionKey(key), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return putMetaFlags(w, newKey, m, flags)
+}
+
+// setExpire changes the expiration of an existing key.
+func setExpire(w writer, key []byte, m *metadata, expire int64) error {
+	if m.expire == expire {
+		return nil
+	}
+	if m.expire != 0 {
+		err := w.Delete(encodeExpireKey(m.expire, key), nil)
+		if err != nil {
+			return err
+		}
+	}
+	m.expire = expire
+	return putMeta(w, key, m)
+}
+
+// getOrNewMeta returns the metadata of key, or a new one of typ if the key does not exist.
+// The leftovers of an expired key are removed first.
+func getOrNewMeta(w writer, key []byte, typ byte) (*metadata, error) {
+	m, err := readMeta(w, key)
+	if err != nil {
+		if err != leveldb.ErrNotFound {
+			return nil, err
+		}
+		return newMeta(w, key, typ)
+	}
+	if m.expired(now()) {
+		err = deleteKey(w, key, m)
+		if err != nil {
+			return nil, err
+		}
+		return newMeta(w, key, typ)
+	}
+	if m.typ != typ {
+		return nil, engine.ErrWrongType
+	}
+	return m, nil
+}
+
+// newMeta returns the metadata of a new key of typ.
+func newMeta(w writer, key []byte, typ byte) (*metadata, error) {
+	m := &metadata{typ: typ}
+	switch typ {
+	case typeString:
+	case typeList:
+		m.setListRange(0, 0)
+	default:
+		m.setCount(0)
+	}
+	return m, nil
+}
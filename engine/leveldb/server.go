@@ -5,11 +5,21 @@ import (
 	"github.com/wzshiming/resp"
 )
 
-func (c *LevelDB) info(name string, args []resp.Reply) (resp.Reply, error) {
+// DBStats populates stats with the statistics of LevelDB.
+func (c *LevelDB) DBStats(stats *leveldb.DBStats) error {
+	return c.db.Stats(stats)
+}
+
+// Stats returns the statistics of LevelDB, which are replied by INFO.
+func (c *LevelDB) Stats() (resp.ReplyMultiBulk, error) {
 	stats := &leveldb.DBStats{}
-	err := c.db.Stats(stats)
+	err := c.DBStats(stats)
+	if err != nil {
+		return nil, err
+	}
+	r, err := resp.ConvertTo(stats)
 	if err != nil {
 		return nil, err
 	}
-	return resp.ConvertTo(stats)
+	return r.(resp.ReplyMultiBulk), nil
 }
@@ -0,0 +1,534 @@
+package leveldb
+
+import (
+	"bytes"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+// The kinds of set algebra.
+const (
+	setInter = iota
+	setUnion
+	setDiff
+)
+
+// setStoreEvents are the keyspace events of storing the results of set algebra.
+var setStoreEvents = []string{
+	setInter: "sinterstore",
+	setUnion: "sunionstore",
+	setDiff:  "sdiffstore",
+}
+
+// setCursor walks the members of a set in order.
+type setCursor struct {
+	iter iterator.Iterator
+	key  []byte
+	ok   bool
+}
+
+func newSetCursor(r leveldb.Reader, key []byte) (*setCursor, error) {
+	_, err := getTypedMeta(r, key, typeSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return &setCursor{key: key}, nil
+		}
+		return nil, err
+	}
+	iter := r.NewIterator(subRange(prefixSet, key, nil, nil), nil)
+	return &setCursor{
+		iter: iter,
+		key:  key,
+		ok:   iter.First(),
+	}, nil
+}
+
+func (s *setCursor) member() []byte {
+	return decodeSubKey(s.iter.Key())
+}
+
+func (s *setCursor) next() {
+	s.ok = s.iter.Next()
+}
+
+// seek moves to the first member not less than member.
+func (s *setCursor) seek(member []byte) {
+	if s.ok {
+		s.ok = s.iter.Seek(encodeSubKey(prefixSet, s.key, member))
+	}
+}
+
+func (s *setCursor) release() error {
+	if s.iter == nil {
+		return nil
+	}
+	s.iter.Release()
+	return s.iter.Error()
+}
+
+// setAlgebra merges the sets of keys in order and calls fn with each member of the result.
+func setAlgebra(r leveldb.Reader, op int, keys [][]byte, fn func(member []byte) error) (err error) {
+	cursors := make([]*setCursor, 0, len(keys))
+	defer func() {
+		for _, cursor := range cursors {
+			if e := cursor.release(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+	for _, key := range keys {
+		cursor, err := newSetCursor(r, key)
+		if err != nil {
+			return err
+		}
+		cursors = append(cursors, cursor)
+	}
+
+	switch op {
+	case setUnion:
+		for {
+			var min []byte
+			for _, cursor := range cursors {
+				if cursor.ok && (min == nil || bytes.Compare(cursor.member(), min) < 0) {
+					min = cursor.member()
+				}
+			}
+			if min == nil {
+				return nil
+			}
+			min = cloneBytes(min)
+			for _, cursor := range cursors {
+				if cursor.ok && bytes.Equal(cursor.member(), min) {
+					cursor.next()
+				}
+			}
+			err = fn(min)
+			if err != nil {
+				return err
+			}
+		}
+
+	case setInter:
+		for {
+			var max []byte
+			for _, cursor := range cursors {
+				if !cursor.ok {
+					return nil
+				}
+				if max == nil || bytes.Compare(cursor.member(), max) > 0 {
+					max = cursor.member()
+				}
+			}
+			max = cloneBytes(max)
+			equal := true
+			for _, cursor := range cursors {
+				cursor.seek(max)
+				if !cursor.ok {
+					return nil
+				}
+				if !bytes.Equal(cursor.member(), max) {
+					equal = false
+				}
+			}
+			if !equal {
+				continue
+			}
+			for _, cursor := range cursors {
+				cursor.next()
+			}
+			err = fn(max)
+			if err != nil {
+				return err
+			}
+		}
+
+	case setDiff:
+		first := cursors[0]
+		for ; first.ok; first.next() {
+			member := first.member()
+			found := false
+			for _, cursor := range cursors[1:] {
+				cursor.seek(member)
+				if cursor.ok && bytes.Equal(cursor.member(), member) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				err = fn(cloneBytes(member))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *LevelDB) sadd(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getOrNewMeta(tran, key, typeSet)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+
+	added := int64(0)
+	for _, arg := range args[1:] {
+		var member []byte
+		err = resp.ConvertFrom(arg, &member)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+
+		sub := encodeSubKey(prefixSet, key, member)
+		_, err = tran.Get(sub, nil)
+		if err == nil {
+			continue
+		}
+		if err != leveldb.ErrNotFound {
+			tran.Discard()
+			return nil, err
+		}
+		err = tran.Put(sub, nil, nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		added++
+	}
+
+	m.setCount(m.count() + added)
+	err = putMeta(tran, key, m)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	if added != 0 {
+		tran.notify(lrdb.NotifySet, "sadd", key)
+	}
+	return resp.ConvertTo(added)
+}
+
+func (c *LevelDB) srem(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getTypedMeta(tran, key, typeSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		tran.Discard()
+		return nil, err
+	}
+
+	removed := int64(0)
+	for _, arg := range args[1:] {
+		var member []byte
+		err = resp.ConvertFrom(arg, &member)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+
+		sub := encodeSubKey(prefixSet, key, member)
+		_, err = tran.Get(sub, nil)
+		if err != nil {
+			if err == leveldb.ErrNotFound {
+				continue
+			}
+			tran.Discard()
+			return nil, err
+		}
+		err = tran.Delete(sub, nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		removed++
+	}
+
+	m.setCount(m.count() - removed)
+	if m.count() <= 0 {
+		err = deleteKey(tran, key, m)
+	} else {
+		err = putMeta(tran, key, m)
+	}
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	if removed != 0 {
+		tran.notify(lrdb.NotifySet, "srem", key)
+	}
+	if m.count() <= 0 {
+		tran.notify(lrdb.NotifyGeneric, "del", key)
+	}
+	return resp.ConvertTo(removed)
+}
+
+func (c *LevelDB) sismember(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 2:
+	}
+
+	var key []byte
+	var member []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &member)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	_, err = getTypedMeta(snap, key, typeSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		return nil, err
+	}
+
+	ok, err := snap.Has(encodeSubKey(prefixSet, key, member), nil)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return reply.One, nil
+	}
+	return reply.Zero, nil
+}
+
+func (c *LevelDB) scard(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getTypedMeta(c.reader(s), key, typeSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		return nil, err
+	}
+	return resp.ConvertTo(m.count())
+}
+
+func (c *LevelDB) smembers(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+	return c.setOp(s, args, setUnion)
+}
+
+func (c *LevelDB) sinter(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 1 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+	return c.setOp(s, args, setInter)
+}
+
+func (c *LevelDB) sunion(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 1 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+	return c.setOp(s, args, setUnion)
+}
+
+func (c *LevelDB) sdiff(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 1 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+	return c.setOp(s, args, setDiff)
+}
+
+func (c *LevelDB) setOp(s *lrdb.Session, args []resp.Reply, op int) (resp.Reply, error) {
+	keys := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		var key []byte
+		err := resp.ConvertFrom(arg, &key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	multiBulk := resp.ReplyMultiBulk{}
+	err = setAlgebra(snap, op, keys, func(member []byte) error {
+		multiBulk = append(multiBulk, resp.ReplyBulk(member))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lrdb.Set{ReplyMultiBulk: multiBulk}, nil
+}
+
+func (c *LevelDB) sinterstore(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+	return c.setOpStore(s, args, setInter)
+}
+
+func (c *LevelDB) sunionstore(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+	return c.setOpStore(s, args, setUnion)
+}
+
+func (c *LevelDB) sdiffstore(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+	return c.setOpStore(s, args, setDiff)
+}
+
+func (c *LevelDB) setOpStore(s *lrdb.Session, args []resp.Reply, op int) (resp.Reply, error) {
+	var dest []byte
+	err := resp.ConvertFrom(args[0], &dest)
+	if err != nil {
+		return nil, err
+	}
+
+	// The result is streamed into destination, unless destination is one of the sources,
+	// then it has to be held in memory until the sources are walked.
+	overlap := false
+	keys := make([][]byte, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		var key []byte
+		err := resp.ConvertFrom(arg, &key)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(key, dest) {
+			overlap = true
+		}
+		keys = append(keys, key)
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := readMeta(tran, dest)
+	existed := err == nil && !m.expired(now())
+	if err == nil && !overlap {
+		err = deleteKey(tran, dest, m)
+	}
+	if err != nil && err != leveldb.ErrNotFound {
+		tran.Discard()
+		return nil, err
+	}
+
+	members := [][]byte{}
+	count := int64(0)
+	err = setAlgebra(tran, op, keys, func(member []byte) error {
+		count++
+		if overlap {
+			members = append(members, member)
+			return nil
+		}
+		return tran.Put(encodeSubKey(prefixSet, dest, member), nil, nil)
+	})
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+
+	if overlap {
+		m, err := readMeta(tran, dest)
+		if err == nil {
+			err = deleteKey(tran, dest, m)
+		}
+		if err != nil && err != leveldb.ErrNotFound {
+			tran.Discard()
+			return nil, err
+		}
+		for _, member := range members {
+			err = tran.Put(encodeSubKey(prefixSet, dest, member), nil, nil)
+			if err != nil {
+				tran.Discard()
+				return nil, err
+			}
+		}
+	}
+
+	if count != 0 {
+		m := &metadata{typ: typeSet}
+		m.setCount(count)
+		err = putMeta(tran, dest, m)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		tran.notify(lrdb.NotifySet, setStoreEvents[op], dest)
+	} else if existed {
+		tran.notify(lrdb.NotifyGeneric, "del", dest)
+	}
+	return resp.ConvertTo(count)
+}
@@ -0,0 +1,364 @@
+package leveldb
+
+import (
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+// execKey is the session value of the transaction that EXEC runs in.
+type execKey struct{}
+
+// transaction is the writes of a command, it's either a transaction of its own,
+// or a part of the transaction of MULTI/EXEC, which is committed by EXEC.
+// It collects the modified keys for WATCH, and the events for the notifier.
+type transaction struct {
+	c       *LevelDB
+	tran    *leveldb.Transaction
+	outer   *transaction
+	touched [][]byte
+	events  []event
+	done    bool // Committed or discarded
+
+	// The savepoint of MULTI/EXEC, the records before the writes of the running command.
+	undos  []undo
+	saved  map[string]bool
+	marked int   // The number of events before the command
+	failed error // The error of a rollback, which aborts EXEC
+}
+
+// undo is a record before it's written, to roll back the writes of a failed command.
+type undo struct {
+	key     []byte
+	value   []byte
+	existed bool
+}
+
+// event is a keyspace event that is notified when the transaction is committed.
+type event struct {
+	class int
+	name  string
+	key   []byte
+}
+
+// begin returns the transaction that a command of the session writes with.
+func (c *LevelDB) begin(s *lrdb.Session) (*transaction, error) {
+	if outer := c.exec(s); outer != nil {
+		return &transaction{
+			c:     c,
+			tran:  outer.tran,
+			outer: outer,
+		}, nil
+	}
+	c.tranMu.Lock()
+	tran, err := c.db.OpenTransaction()
+	if err != nil {
+		c.tranMu.Unlock()
+		return nil, err
+	}
+	return &transaction{
+		c:    c,
+		tran: tran,
+	}, nil
+}
+
+// exec returns the transaction of MULTI/EXEC if the session is running it.
+func (c *LevelDB) exec(s *lrdb.Session) *transaction {
+	if s == nil {
+		return nil
+	}
+	t, _ := s.Value(execKey{}).(*transaction)
+	return t
+}
+
+// transactor runs the commands of EXEC in one transaction.
+func (c *LevelDB) transactor(s *lrdb.Session, fn func() error) error {
+	t, err := c.begin(nil)
+	if err != nil {
+		return err
+	}
+	s.SetValue(execKey{}, t)
+	defer s.SetValue(execKey{}, nil)
+
+	err = fn()
+	if err == nil {
+		err = t.failed
+	}
+	if err != nil {
+		t.Discard()
+		return err
+	}
+	return t.Commit()
+}
+
+// rollback returns a middleware that undoes the writes of a command that fails in MULTI/EXEC,
+// so EXEC replies the error in place of its result and still commits the other commands.
+func (c *LevelDB) rollback(next lrdb.CmdFunc) lrdb.CmdFunc {
+	return func(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+		t := c.exec(s)
+		if t == nil {
+			return next(s, name, args)
+		}
+		t.savepoint()
+		result, err := next(s, name, args)
+		if err != nil {
+			t.rollback()
+		}
+		return result, err
+	}
+}
+
+// savepoint starts recording the records before they are written.
+func (t *transaction) savepoint() {
+	t.undos = t.undos[:0]
+	t.saved = map[string]bool{}
+	t.marked = len(t.events)
+}
+
+// save records key before it's written for the first time since the savepoint.
+func (t *transaction) save(key []byte) error {
+	if t.saved == nil || t.saved[string(key)] {
+		return nil
+	}
+	value, err := t.tran.Get(key, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	t.saved[string(key)] = true
+	t.undos = append(t.undos, undo{cloneBytes(key), value, err == nil})
+	return nil
+}
+
+// rollback restores the records written since the savepoint and drops their events.
+func (t *transaction) rollback() {
+	for i := len(t.undos) - 1; i >= 0 && t.failed == nil; i-- {
+		u := t.undos[i]
+		if u.existed {
+			t.failed = t.tran.Put(u.key, u.value, nil)
+		} else {
+			t.failed = t.tran.Delete(u.key, nil)
+		}
+	}
+	t.undos = t.undos[:0]
+	t.saved = map[string]bool{}
+	t.events = t.events[:t.marked]
+}
+
+func (t *transaction) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	return t.tran.Get(key, ro)
+}
+
+func (t *transaction) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	return t.tran.Has(key, ro)
+}
+
+func (t *transaction) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return t.tran.NewIterator(slice, ro)
+}
+
+func (t *transaction) Put(key, value []byte, wo *opt.WriteOptions) error {
+	t.touch(key)
+	if t.outer != nil {
+		err := t.outer.save(key)
+		if err != nil {
+			return err
+		}
+	}
+	return t.tran.Put(key, value, wo)
+}
+
+func (t *transaction) Delete(key []byte, wo *opt.WriteOptions) error {
+	t.touch(key)
+	if t.outer != nil {
+		err := t.outer.save(key)
+		if err != nil {
+			return err
+		}
+	}
+	return t.tran.Delete(key, wo)
+}
+
+// touch records the user key if the metadata record is written, every modification writes it.
+func (t *transaction) touch(key []byte) {
+	if len(key) == 0 || key[0] != prefixMeta {
+		return
+	}
+	if t.outer != nil {
+		t.outer.touch(key)
+		return
+	}
+	t.touched = append(t.touched, cloneBytes(decodeMetaKey(key)))
+}
+
+// notify records a keyspace event, it's notified if the transaction is committed.
+func (t *transaction) notify(class int, name string, key []byte) {
+	if t.c.notifier == nil {
+		return
+	}
+	if t.outer != nil {
+		t.outer.notify(class, name, key)
+		return
+	}
+	t.events = append(t.events, event{class, name, cloneBytes(key)})
+}
+
+// Commit commits the transaction, a part of MULTI/EXEC is left to EXEC.
+// It does nothing if the transaction is discarded.
+func (t *transaction) Commit() error {
+	if t.outer != nil || t.done {
+		return nil
+	}
+	t.done = true
+	// The watchers are told before the writes are visible,
+	// so an EXEC that gets in the transaction after this is sure to see it.
+	t.c.watcher.touch(t.touched)
+	err := t.tran.Commit()
+	t.c.tranMu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, e := range t.events {
+		t.c.notifier.Notify(e.class, e.name, e.key)
+	}
+	return nil
+}
+
+// Discard discards the transaction, a part of MULTI/EXEC is left to EXEC,
+// whose writes of the failed command are rolled back by the rollback middleware.
+func (t *transaction) Discard() {
+	if t.outer != nil || t.done {
+		return
+	}
+	t.done = true
+	t.tran.Discard()
+	t.c.tranMu.Unlock()
+}
+
+// snapshot is a consistent view for the reads of a command.
+type snapshot interface {
+	leveldb.Reader
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	Release()
+}
+
+// execSnapshot reads the transaction of MULTI/EXEC, so the writes of the previous commands are seen.
+type execSnapshot struct {
+	*transaction
+}
+
+func (execSnapshot) Release() {}
+
+// snapshot returns the view that a command of the session reads.
+func (c *LevelDB) snapshot(s *lrdb.Session) (snapshot, error) {
+	if t := c.exec(s); t != nil {
+		return execSnapshot{t}, nil
+	}
+	return c.db.GetSnapshot()
+}
+
+// reader returns what a command of the session reads single records from.
+func (c *LevelDB) reader(s *lrdb.Session) leveldb.Reader {
+	if t := c.exec(s); t != nil {
+		return t
+	}
+	return c.db
+}
+
+// watcher tracks the keys watched by sessions.
+type watcher struct {
+	mu   sync.Mutex
+	keys map[string]map[*lrdb.Session]struct{}
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		keys: map[string]map[*lrdb.Session]struct{}{},
+	}
+}
+
+func (w *watcher) watch(s *lrdb.Session, keys []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		sessions, ok := w.keys[key]
+		if !ok {
+			sessions = map[*lrdb.Session]struct{}{}
+			w.keys[key] = sessions
+		}
+		sessions[s] = struct{}{}
+	}
+}
+
+func (w *watcher) unwatch(s *lrdb.Session, keys []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, key := range keys {
+		sessions, ok := w.keys[key]
+		if !ok {
+			continue
+		}
+		delete(sessions, s)
+		if len(sessions) == 0 {
+			delete(w.keys, key)
+		}
+	}
+}
+
+// touch marks the sessions watching any of keys.
+func (w *watcher) touch(keys [][]byte) {
+	if len(keys) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.keys) == 0 {
+		return
+	}
+	for _, key := range keys {
+		for s := range w.keys[string(key)] {
+			s.Touch()
+		}
+	}
+}
+
+func (c *LevelDB) watch(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) == 0 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+	if s.InMulti() {
+		return nil, engine.ErrWatchInsideMulti
+	}
+
+	keys := make([]string, 0, len(args))
+	for _, arg := range args {
+		var key string
+		err := resp.ConvertFrom(arg, &key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	c.watcher.watch(s, keys)
+	s.Watch(func() {
+		c.watcher.unwatch(s, keys)
+	})
+	return reply.OK, nil
+}
+
+func (c *LevelDB) unwatch(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 0:
+	}
+	s.Unwatch()
+	return reply.OK, nil
+}
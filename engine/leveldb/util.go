@@ -16,7 +16,7 @@ func bytesPrefix(prefix []byte) *util.Range {
 			break
 		}
 	}
-	return &util.Range{prefix, limit}
+	return &util.Range{Start: prefix, Limit: limit}
 }
 
 // bytesNext returns the next in the current bytes.
@@ -0,0 +1,673 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+// A sorted set stores the score of each member under prefixZSet,
+// and an empty entry for each member under prefixZScore ordered by score and then member,
+// the ranges by score and by rank are walks over the latter.
+
+var (
+	ErrNotFloat = errors.New("Error value is not a valid float")
+)
+
+// encodeScore returns the order preserving bytes of a score.
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if bits&(1<<63) == 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+func decodeScore(data []byte) float64 {
+	bits := binary.BigEndian.Uint64(data)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+func encodeZScoreKey(key []byte, score float64, member []byte) []byte {
+	return encodeSubKey(prefixZScore, key, append(encodeScore(score), member...))
+}
+
+func decodeZScoreKey(data []byte) (float64, []byte) {
+	sub := decodeSubKey(data)
+	return decodeScore(sub), sub[8:]
+}
+
+func formatScore(score float64) resp.ReplyBulk {
+	switch {
+	case math.IsInf(score, 1):
+		return resp.ReplyBulk("inf")
+	case math.IsInf(score, -1):
+		return resp.ReplyBulk("-inf")
+	}
+	return resp.ReplyBulk(strconv.FormatFloat(score, 'g', -1, 64))
+}
+
+func parseScore(r resp.Reply) (float64, error) {
+	var data string
+	err := resp.ConvertFrom(r, &data)
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(data, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, ErrNotFloat
+	}
+	// Turns -0 into 0, they would be ordered differently.
+	return score + 0, nil
+}
+
+// scoreBound is an end of score range, it excludes the score itself if it is given as "(score".
+type scoreBound struct {
+	score     float64
+	exclusive bool
+}
+
+func parseScoreBound(r resp.Reply) (scoreBound, error) {
+	var data []byte
+	err := resp.ConvertFrom(r, &data)
+	if err != nil {
+		return scoreBound{}, err
+	}
+	bound := scoreBound{}
+	if len(data) != 0 && data[0] == '(' {
+		bound.exclusive = true
+		data = data[1:]
+	}
+	bound.score, err = parseScore(resp.ReplyBulk(data))
+	if err != nil {
+		return scoreBound{}, err
+	}
+	return bound, nil
+}
+
+// zsetScore returns the score of member, and false if it is not in the sorted set.
+func zsetScore(r leveldb.Reader, key, member []byte) (float64, bool, error) {
+	val, err := r.Get(encodeSubKey(prefixZSet, key, member), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return decodeScore(val), true, nil
+}
+
+// zsetPut sets the score of member, and returns true if member is new.
+func zsetPut(w writer, key, member []byte, score float64) (bool, error) {
+	old, ok, err := zsetScore(w, key, member)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		if old == score {
+			return false, nil
+		}
+		err = w.Delete(encodeZScoreKey(key, old, member), nil)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = w.Put(encodeSubKey(prefixZSet, key, member), encodeScore(score), nil)
+	if err != nil {
+		return false, err
+	}
+	err = w.Put(encodeZScoreKey(key, score, member), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+func (c *LevelDB) zadd(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]float64, 0, len(args)/2)
+	members := make([][]byte, 0, len(args)/2)
+	for i := 1; i != len(args); i += 2 {
+		var member []byte
+		score, err := parseScore(args[i])
+		if err != nil {
+			return nil, err
+		}
+		err = resp.ConvertFrom(args[i+1], &member)
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, score)
+		members = append(members, member)
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getOrNewMeta(tran, key, typeZSet)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+
+	added := int64(0)
+	for i, member := range members {
+		ok, err := zsetPut(tran, key, member, scores[i])
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		if ok {
+			added++
+		}
+	}
+
+	m.setCount(m.count() + added)
+	err = putMeta(tran, key, m)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	tran.notify(lrdb.NotifyZSet, "zadd", key)
+	return resp.ConvertTo(added)
+}
+
+func (c *LevelDB) zincrby(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 3:
+	}
+
+	var key []byte
+	var member []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	incr, err := parseScore(args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[2], &member)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getOrNewMeta(tran, key, typeZSet)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+
+	score, _, err := zsetScore(tran, key, member)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	score += incr
+	if math.IsNaN(score) {
+		tran.Discard()
+		return nil, ErrNotFloat
+	}
+
+	ok, err := zsetPut(tran, key, member, score)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	if ok {
+		m.setCount(m.count() + 1)
+	}
+	err = putMeta(tran, key, m)
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	tran.notify(lrdb.NotifyZSet, "zincr", key)
+	return lrdb.Double{ReplyBulk: formatScore(score)}, nil
+}
+
+func (c *LevelDB) zrem(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) < 2 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	tran, err := c.begin(s)
+	if err != nil {
+		return nil, err
+	}
+	defer tran.Commit()
+
+	m, err := getTypedMeta(tran, key, typeZSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		tran.Discard()
+		return nil, err
+	}
+
+	removed := int64(0)
+	for _, arg := range args[1:] {
+		var member []byte
+		err = resp.ConvertFrom(arg, &member)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+
+		score, ok, err := zsetScore(tran, key, member)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		err = tran.Delete(encodeSubKey(prefixZSet, key, member), nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		err = tran.Delete(encodeZScoreKey(key, score, member), nil)
+		if err != nil {
+			tran.Discard()
+			return nil, err
+		}
+		removed++
+	}
+
+	m.setCount(m.count() - removed)
+	if m.count() <= 0 {
+		err = deleteKey(tran, key, m)
+	} else {
+		err = putMeta(tran, key, m)
+	}
+	if err != nil {
+		tran.Discard()
+		return nil, err
+	}
+	if removed != 0 {
+		tran.notify(lrdb.NotifyZSet, "zrem", key)
+	}
+	if m.count() <= 0 {
+		tran.notify(lrdb.NotifyGeneric, "del", key)
+	}
+	return resp.ConvertTo(removed)
+}
+
+func (c *LevelDB) zcard(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 1:
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := getTypedMeta(c.reader(s), key, typeZSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return reply.Zero, nil
+		}
+		return nil, err
+	}
+	return resp.ConvertTo(m.count())
+}
+
+func (c *LevelDB) zscore(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 2:
+	}
+
+	var key []byte
+	var member []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &member)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	_, err = getTypedMeta(snap, key, typeZSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return resp.ReplyBulk(nil), nil
+		}
+		return nil, err
+	}
+
+	score, ok, err := zsetScore(snap, key, member)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return resp.ReplyBulk(nil), nil
+	}
+	return lrdb.Double{ReplyBulk: formatScore(score)}, nil
+}
+
+func (c *LevelDB) zrank(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.zrankBy(s, args, false)
+}
+
+func (c *LevelDB) zrevrank(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.zrankBy(s, args, true)
+}
+
+func (c *LevelDB) zrankBy(s *lrdb.Session, args []resp.Reply, reverse bool) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 2:
+	}
+
+	var key []byte
+	var member []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &member)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	m, err := getTypedMeta(snap, key, typeZSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return resp.ReplyBulk(nil), nil
+		}
+		return nil, err
+	}
+
+	score, ok, err := zsetScore(snap, key, member)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return resp.ReplyBulk(nil), nil
+	}
+
+	// The rank is the number of members in front of it in the index.
+	urange := subRange(prefixZScore, key, nil, nil)
+	urange.Limit = encodeZScoreKey(key, score, member)
+	iter := snap.NewIterator(urange, nil)
+	defer iter.Release()
+
+	rank := int64(0)
+	for iter.Next() {
+		rank++
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	if reverse {
+		rank = m.count() - 1 - rank
+	}
+	return resp.ConvertTo(rank)
+}
+
+func (c *LevelDB) zrange(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.zrangeBy(s, args, false)
+}
+
+func (c *LevelDB) zrevrange(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.zrangeBy(s, args, true)
+}
+
+func (c *LevelDB) zrangeBy(s *lrdb.Session, args []resp.Reply, reverse bool) (resp.Reply, error) {
+	withScores := false
+	switch len(args) {
+	default:
+		return nil, engine.ErrWrongNumberOfArguments
+	case 4:
+		var opt string
+		err := resp.ConvertFrom(args[3], &opt)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(opt, "withscores") {
+			return nil, engine.ErrSyntax
+		}
+		withScores = true
+	case 3:
+	}
+
+	var key []byte
+	var start int64
+	var stop int64
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &start)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[2], &stop)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	multiBulk := resp.ReplyMultiBulk{}
+	m, err := getTypedMeta(snap, key, typeZSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return multiBulk, nil
+		}
+		return nil, err
+	}
+
+	start, stop, ok := listOffsets(start, stop, m.count())
+	if !ok {
+		return multiBulk, nil
+	}
+
+	iter := snap.NewIterator(subRange(prefixZScore, key, nil, nil), nil)
+	defer iter.Release()
+
+	first, next := iter.First, iter.Next
+	if reverse {
+		first, next = iter.Last, iter.Prev
+	}
+	for i, ok := int64(0), first(); ok && i <= stop; i, ok = i+1, next() {
+		if i < start {
+			continue
+		}
+		score, member := decodeZScoreKey(iter.Key())
+		multiBulk = append(multiBulk, resp.ReplyBulk(cloneBytes(member)))
+		if withScores {
+			multiBulk = append(multiBulk, lrdb.Double{ReplyBulk: formatScore(score)})
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return multiBulk, nil
+}
+
+func (c *LevelDB) zrangebyscore(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.zrangeByScore(s, args, false)
+}
+
+func (c *LevelDB) zrevrangebyscore(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	return c.zrangeByScore(s, args, true)
+}
+
+func (c *LevelDB) zrangeByScore(s *lrdb.Session, args []resp.Reply, reverse bool) (resp.Reply, error) {
+	if len(args) < 3 {
+		return nil, engine.ErrWrongNumberOfArguments
+	}
+
+	var key []byte
+	err := resp.ConvertFrom(args[0], &key)
+	if err != nil {
+		return nil, err
+	}
+	min, err := parseScoreBound(args[1])
+	if err != nil {
+		return nil, err
+	}
+	max, err := parseScoreBound(args[2])
+	if err != nil {
+		return nil, err
+	}
+	if reverse {
+		min, max = max, min
+	}
+
+	withScores := false
+	offset := int64(0)
+	count := int64(-1)
+	for i := 3; i < len(args); i++ {
+		var opt string
+		err = resp.ConvertFrom(args[i], &opt)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		default:
+			return nil, engine.ErrSyntax
+		case strings.EqualFold(opt, "withscores"):
+			withScores = true
+		case strings.EqualFold(opt, "limit") && i+2 < len(args):
+			err = resp.ConvertFrom(args[i+1], &offset)
+			if err != nil {
+				return nil, err
+			}
+			err = resp.ConvertFrom(args[i+2], &count)
+			if err != nil {
+				return nil, err
+			}
+			i += 2
+		}
+	}
+
+	multiBulk := resp.ReplyMultiBulk{}
+	if min.score > max.score || offset < 0 || count == 0 {
+		return multiBulk, nil
+	}
+
+	snap, err := c.snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+
+	_, err = getTypedMeta(snap, key, typeZSet)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			return multiBulk, nil
+		}
+		return nil, err
+	}
+
+	urange := &util.Range{
+		Start: encodeSubKey(prefixZScore, key, encodeScore(min.score)),
+		Limit: bytesPrefix(encodeSubKey(prefixZScore, key, encodeScore(max.score))).Limit,
+	}
+	if urange.Limit == nil {
+		urange.Limit = subRange(prefixZScore, key, nil, nil).Limit
+	}
+	iter := snap.NewIterator(urange, nil)
+	defer iter.Release()
+
+	first, next := iter.First, iter.Next
+	if reverse {
+		first, next = iter.Last, iter.Prev
+	}
+	for ok := first(); ok && count != 0; ok = next() {
+		score, member := decodeZScoreKey(iter.Key())
+		if (min.exclusive && score == min.score) || (max.exclusive && score == max.score) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		multiBulk = append(multiBulk, resp.ReplyBulk(cloneBytes(member)))
+		if withScores {
+			multiBulk = append(multiBulk, lrdb.Double{ReplyBulk: formatScore(score)})
+		}
+		count--
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return multiBulk, nil
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/resp"
+)
+
+// Middleware wraps the handler of commands, it's added by Commands.Use.
+type Middleware func(next lrdb.CmdFunc) lrdb.CmdFunc
+
+// Recovery returns a middleware that turns a panic of a command into an error,
+// the panic and its stack are written to logger if it's not nil.
+func Recovery(logger lrdb.Logger) Middleware {
+	return func(next lrdb.CmdFunc) lrdb.CmdFunc {
+		return func(s *lrdb.Session, name string, args []resp.Reply) (result resp.Reply, err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				if logger != nil {
+					stack := make([]byte, 4096)
+					stack = stack[:runtime.Stack(stack, false)]
+					logger.Log(lrdb.LevelError, "Panic", lrdb.F("command", name), lrdb.F("error", fmt.Sprint(r)),
+						lrdb.F("stack", string(stack)))
+				}
+				result, err = nil, fmt.Errorf("Error panic in command '%s': %v", name, r)
+			}()
+			return next(s, name, args)
+		}
+	}
+}
+
+// Timing returns a middleware that calls observe with the duration of every command.
+func Timing(observe func(s *lrdb.Session, name string, args []resp.Reply, d time.Duration, err error)) Middleware {
+	return func(next lrdb.CmdFunc) lrdb.CmdFunc {
+		return func(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+			start := time.Now()
+			result, err := next(s, name, args)
+			observe(s, name, args, time.Since(start), err)
+			return result, err
+		}
+	}
+}
+
+// maxLogArg is the max length of an argument that is logged.
+const maxLogArg = 64
+
+// Logging returns a middleware that logs every command at level with its client, duration and error,
+// the failed ones are logged at the level of warnings if level is lower.
+func Logging(logger lrdb.Logger, level lrdb.Level) Middleware {
+	enabled, _ := logger.(interface{ Enabled(lrdb.Level) bool })
+	return Timing(func(s *lrdb.Session, name string, args []resp.Reply, d time.Duration, err error) {
+		lvl := level
+		// QUIT and SHUTDOWN end with errors for the server, they are not failures.
+		failed := err != nil && err != lrdb.ErrQuit && err != lrdb.ErrShutdown
+		if failed && lvl < lrdb.LevelWarn {
+			lvl = lrdb.LevelWarn
+		}
+		// The arguments are not formatted if the entry is discarded.
+		if enabled != nil && !enabled.Enabled(lvl) {
+			return
+		}
+
+		fields := make([]lrdb.Field, 0, 5)
+		if client := s.Client(); client != nil {
+			fields = append(fields, lrdb.F("addr", client.Conn().RemoteAddr()))
+		}
+		fields = append(fields, lrdb.F("command", name))
+		if lrdb.SensitiveCommands[name] {
+			fields = append(fields, lrdb.F("args", "(redacted)"))
+		} else {
+			fields = append(fields, lrdb.F("args", strings.TrimPrefix(formatArgs(args), " ")))
+		}
+		fields = append(fields, lrdb.F("duration", d))
+		if failed {
+			fields = append(fields, lrdb.F("error", err))
+		}
+		logger.Log(lvl, "Command", fields...)
+	})
+}
+
+// formatArgs returns the quoted arguments, the long ones are truncated.
+func formatArgs(args []resp.Reply) string {
+	buf := strings.Builder{}
+	for _, arg := range args {
+		var val string
+		err := resp.ConvertFrom(arg, &val)
+		if err != nil {
+			val = arg.Format(0)
+		}
+		buf.WriteByte(' ')
+		if len(val) > maxLogArg {
+			buf.WriteString(strconv.Quote(val[:maxLogArg]))
+			buf.WriteString("...")
+		} else {
+			buf.WriteString(strconv.Quote(val))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+var (
+	ErrSubscriberMode   = errors.New("Error only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+	ErrPubSubNotEnable  = errors.New("Error pub/sub is not enabled for the connection")
+	ErrMonitorNotEnable = errors.New("Error MONITOR is not enabled for the connection")
+)
+
+// subscriberMode are the commands that are allowed in the subscriber mode of RESP2,
+// RESP3 allows all commands since the messages are distinguished as pushes.
+var subscriberMode = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+}
+
+func subscribeReplies(kind string, names []string, counts []int) lrdb.Replies {
+	replies := make(resp.ReplyMultiBulk, 0, len(names))
+	for i, name := range names {
+		replies = append(replies, lrdb.Push{ReplyMultiBulk: resp.ReplyMultiBulk{
+			resp.ReplyBulk(kind),
+			resp.ReplyBulk(name),
+			resp.ReplyInteger(strconv.Itoa(counts[i])),
+		}})
+	}
+	return lrdb.Replies{ReplyMultiBulk: replies}
+}
+
+func convertStrings(args []resp.Reply) ([]string, error) {
+	names := make([]string, 0, len(args))
+	for _, arg := range args {
+		var name string
+		err := resp.ConvertFrom(arg, &name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (c *Commands) cmdSubscribe(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) == 0 {
+		return nil, ErrWrongNumberOfArguments
+	}
+	if s.Broker() == nil || s.Subscriber() == nil {
+		return nil, ErrPubSubNotEnable
+	}
+	channels, err := convertStrings(args)
+	if err != nil {
+		return nil, err
+	}
+	counts := s.Broker().Subscribe(s.Subscriber(), channels...)
+	return subscribeReplies(name, channels, counts), nil
+}
+
+func (c *Commands) cmdPSubscribe(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if len(args) == 0 {
+		return nil, ErrWrongNumberOfArguments
+	}
+	if s.Broker() == nil || s.Subscriber() == nil {
+		return nil, ErrPubSubNotEnable
+	}
+	patterns, err := convertStrings(args)
+	if err != nil {
+		return nil, err
+	}
+	counts := s.Broker().PSubscribe(s.Subscriber(), patterns...)
+	return subscribeReplies(name, patterns, counts), nil
+}
+
+func (c *Commands) cmdUnsubscribe(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if s.Broker() == nil || s.Subscriber() == nil {
+		return nil, ErrPubSubNotEnable
+	}
+	channels, err := convertStrings(args)
+	if err != nil {
+		return nil, err
+	}
+	channels, counts := s.Broker().Unsubscribe(s.Subscriber(), channels...)
+	return unsubscribeReplies(s, name, channels, counts), nil
+}
+
+func (c *Commands) cmdPUnsubscribe(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if s.Broker() == nil || s.Subscriber() == nil {
+		return nil, ErrPubSubNotEnable
+	}
+	patterns, err := convertStrings(args)
+	if err != nil {
+		return nil, err
+	}
+	patterns, counts := s.Broker().PUnsubscribe(s.Subscriber(), patterns...)
+	return unsubscribeReplies(s, name, patterns, counts), nil
+}
+
+// unsubscribeReplies replies once even if there was nothing to unsubscribe from.
+func unsubscribeReplies(s *lrdb.Session, kind string, names []string, counts []int) resp.Reply {
+	if len(names) == 0 {
+		return lrdb.Push{ReplyMultiBulk: resp.ReplyMultiBulk{
+			resp.ReplyBulk(kind),
+			resp.ReplyBulk(nil),
+			resp.ReplyInteger(strconv.Itoa(s.Subscriber().Count())),
+		}}
+	}
+	return subscribeReplies(kind, names, counts)
+}
+
+// cmdMonitor makes the connection receive every command run by the server, until it's closed.
+func (c *Commands) cmdMonitor(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	if s.Broker() == nil || s.Subscriber() == nil {
+		return nil, ErrMonitorNotEnable
+	}
+	s.Broker().Monitor(s.Subscriber())
+	return reply.OK, nil
+}
+
+func (c *Commands) cmdPublish(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, ErrWrongNumberOfArguments
+	case 2:
+	}
+	if s.Broker() == nil {
+		return nil, ErrPubSubNotEnable
+	}
+
+	var channel string
+	var payload []byte
+	err := resp.ConvertFrom(args[0], &channel)
+	if err != nil {
+		return nil, err
+	}
+	err = resp.ConvertFrom(args[1], &payload)
+	if err != nil {
+		return nil, err
+	}
+	return resp.ConvertTo(s.Broker().Publish(channel, payload))
+}
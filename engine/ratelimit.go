@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/resp"
+)
+
+var ErrRateLimited = errors.New("RATELIMITED too many commands, try again later")
+
+// The ways clients are told apart by the rate limits.
+const (
+	RateLimitByAddr = "addr" // The remote host of the connection
+	RateLimitByUser = "user" // The authenticated user of ACL, the default user before AUTH
+	RateLimitByName = "name" // The client name, the address if no name is assigned
+)
+
+// rateLimitSweep is the number of client buckets over which the full ones are removed, at most once a second.
+const rateLimitSweep = 1024
+
+// RateLimit is a token bucket that refills Rate tokens per second up to Burst,
+// a command takes a token. A zero Rate means no limit, and a zero Burst is one second of Rate.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	if l.Rate < 1 {
+		return 1
+	}
+	return l.Rate
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens since the last time, a new bucket is full.
+func (b *bucket) refill(l RateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = l.burst()
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.Rate
+		if max := l.burst(); b.tokens > max {
+			b.tokens = max
+		}
+	}
+	b.last = now
+}
+
+// RateLimiter limits the read and the write commands run by each client and by all clients,
+// the other commands are not limited.
+type RateLimiter struct {
+	mu       sync.Mutex
+	by       string
+	client   map[string]RateLimit // By category
+	global   map[string]RateLimit
+	buckets  map[string]*bucket // By category and client
+	globals  map[string]*bucket
+	rejected map[string]uint64 // By scope and category
+	swept    time.Time
+}
+
+// NewRateLimiter returns a rate limiter without limits, which tells clients apart by by.
+func NewRateLimiter(by string) (*RateLimiter, error) {
+	switch by {
+	default:
+		return nil, fmt.Errorf("Error invalid rate limit by '%s'", by)
+	case RateLimitByAddr, RateLimitByUser, RateLimitByName:
+	}
+	return &RateLimiter{
+		by:       by,
+		client:   map[string]RateLimit{},
+		global:   map[string]RateLimit{},
+		buckets:  map[string]*bucket{},
+		globals:  map[string]*bucket{},
+		rejected: map[string]uint64{},
+	}, nil
+}
+
+// SetClientLimit sets the limit of each client on the commands of category, CategoryRead or CategoryWrite.
+func (r *RateLimiter) SetClientLimit(category string, limit RateLimit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.client[category] = limit
+	for key := range r.buckets {
+		if strings.HasPrefix(key, category+":") {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// SetGlobalLimit sets the limit of all clients together on the commands of category.
+func (r *RateLimiter) SetGlobalLimit(category string, limit RateLimit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.global[category] = limit
+	delete(r.globals, category)
+}
+
+// Allow takes a token of the client and a global one for a command of category,
+// ErrRateLimited is returned if either runs out, and no token is taken.
+func (r *RateLimiter) Allow(client, category string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+
+	var cb, gb *bucket
+	climit, glimit := r.client[category], r.global[category]
+	if climit.Rate > 0 {
+		key := category + ":" + client
+		cb = r.buckets[key]
+		if cb == nil {
+			if len(r.buckets) >= rateLimitSweep && now.Sub(r.swept) > time.Second {
+				r.sweep(now)
+			}
+			cb = &bucket{}
+			r.buckets[key] = cb
+		}
+		cb.refill(climit, now)
+		if cb.tokens < 1 {
+			r.rejected["client_"+category]++
+			return ErrRateLimited
+		}
+	}
+	if glimit.Rate > 0 {
+		gb = r.globals[category]
+		if gb == nil {
+			gb = &bucket{}
+			r.globals[category] = gb
+		}
+		gb.refill(glimit, now)
+		if gb.tokens < 1 {
+			r.rejected["global_"+category]++
+			return ErrRateLimited
+		}
+	}
+
+	if cb != nil {
+		cb.tokens--
+	}
+	if gb != nil {
+		gb.tokens--
+	}
+	return nil
+}
+
+// sweep removes the client buckets that are full, they are the same as new ones.
+func (r *RateLimiter) sweep(now time.Time) {
+	r.swept = now
+	for key, b := range r.buckets {
+		category := key[:strings.IndexByte(key, ':')]
+		limit := r.client[category]
+		b.refill(limit, now)
+		if b.tokens >= limit.burst() {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// Stats returns the counters of the rejected commands, as pairs of names and values,
+// the names are like the ones of the statistics of LevelDB.
+func (r *RateLimiter) Stats() resp.ReplyMultiBulk {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := resp.ReplyMultiBulk{}
+	for _, counter := range []struct{ name, key string }{
+		{"RateLimitClientReadRejected", "client_" + CategoryRead},
+		{"RateLimitClientWriteRejected", "client_" + CategoryWrite},
+		{"RateLimitGlobalReadRejected", "global_" + CategoryRead},
+		{"RateLimitGlobalWriteRejected", "global_" + CategoryWrite},
+	} {
+		stats = append(stats,
+			resp.ReplyBulk(counter.name),
+			resp.ReplyInteger(strconv.FormatUint(r.rejected[counter.key], 10)),
+		)
+	}
+	stats = append(stats,
+		resp.ReplyBulk("RateLimitClients"),
+		resp.ReplyInteger(strconv.Itoa(len(r.buckets))),
+	)
+	return stats
+}
+
+// rateLimiting is the middleware of the rate limiter, the queued commands are limited when EXEC runs them,
+// so a limited one fails in the reply of EXEC.
+func (c *Commands) rateLimiting(next lrdb.CmdFunc) lrdb.CmdFunc {
+	return func(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+		// The commands of a session that is not authenticated are refused by ACL, they take no tokens.
+		if c.acl != nil {
+			if _, ok := c.acl.whoami(s); !ok {
+				return next(s, name, args)
+			}
+		}
+		category := c.commandInfo(name).Category
+		if category == CategoryRead || category == CategoryWrite {
+			err := c.limiter.Allow(c.limiter.clientOf(s), category)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return next(s, name, args)
+	}
+}
+
+// clientOf returns the client of the session that the limits apply to.
+func (r *RateLimiter) clientOf(s *lrdb.Session) string {
+	switch r.by {
+	case RateLimitByUser:
+		if name, ok := s.Value(userKey{}).(string); ok {
+			return name
+		}
+		return DefaultUser
+	case RateLimitByName:
+		if client := s.Client(); client != nil && client.Name() != "" {
+			return client.Name()
+		}
+	}
+	// The sessions of HTTP have no client, they share a bucket.
+	client := s.Client()
+	if client == nil {
+		return ""
+	}
+	// The connections of a host share a bucket, whatever their ports are.
+	addr := client.Conn().RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+const (
+	// slowLogMaxArgs is the max number of the arguments kept of an entry.
+	slowLogMaxArgs = 32
+	// slowLogMaxArgLen is the max length of an argument kept.
+	slowLogMaxArgLen = 128
+)
+
+// SlowLogEntry is a command that was slower than the threshold.
+type SlowLogEntry struct {
+	ID       uint64
+	Time     time.Time
+	Duration time.Duration
+	Args     []string // With the name, truncated like Redis
+	Addr     string
+	Name     string
+}
+
+// SlowLog keeps the latest commands that were slower than the threshold.
+type SlowLog struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	maxLen    int
+	entries   []*SlowLogEntry // The newest first
+	lastID    uint64
+}
+
+// NewSlowLog returns a slow log of the commands slower than threshold, it keeps up to maxLen entries.
+// A negative threshold disables it, and 0 logs every command.
+func NewSlowLog(threshold time.Duration, maxLen int) *SlowLog {
+	return &SlowLog{
+		threshold: threshold,
+		maxLen:    maxLen,
+	}
+}
+
+// SetThreshold sets the threshold, a negative one disables the slow log.
+func (l *SlowLog) SetThreshold(threshold time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.threshold = threshold
+}
+
+// SetMaxLen sets the max number of entries, the oldest ones over it are removed.
+func (l *SlowLog) SetMaxLen(maxLen int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxLen = maxLen
+	if len(l.entries) > maxLen {
+		l.entries = l.entries[:maxLen]
+	}
+}
+
+// Add logs the command if d is over the threshold.
+func (l *SlowLog) Add(s *lrdb.Session, name string, args []resp.Reply, d time.Duration) {
+	l.mu.Lock()
+	threshold, maxLen := l.threshold, l.maxLen
+	l.mu.Unlock()
+	if threshold < 0 || d < threshold || maxLen <= 0 {
+		return
+	}
+
+	entry := &SlowLogEntry{
+		Time:     time.Now(),
+		Duration: d,
+		Args:     slowLogArgs(name, args),
+	}
+	if client := s.Client(); client != nil {
+		entry.Addr = client.Conn().RemoteAddr().String()
+		entry.Name = client.Name()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastID++
+	entry.ID = l.lastID
+	l.entries = append(l.entries, nil)
+	copy(l.entries[1:], l.entries)
+	l.entries[0] = entry
+	if len(l.entries) > l.maxLen {
+		l.entries = l.entries[:l.maxLen]
+	}
+}
+
+// Get returns up to n of the newest entries, all of them if n is negative.
+func (l *SlowLog) Get(n int) []*SlowLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n < 0 || n > len(l.entries) {
+		n = len(l.entries)
+	}
+	entries := make([]*SlowLogEntry, n)
+	copy(entries, l.entries)
+	return entries
+}
+
+// Len returns the number of entries.
+func (l *SlowLog) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// Reset removes all entries.
+func (l *SlowLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+}
+
+// SlowLogging returns a middleware that adds the commands slower than the threshold to l.
+func SlowLogging(l *SlowLog) Middleware {
+	return Timing(func(s *lrdb.Session, name string, args []resp.Reply, d time.Duration, err error) {
+		l.Add(s, name, args, d)
+	})
+}
+
+// slowLogArgs converts the name and the arguments, the ones over the max number and the long ones are truncated like Redis.
+// The arguments of lrdb.SensitiveCommands are redacted.
+func slowLogArgs(name string, args []resp.Reply) []string {
+	if lrdb.SensitiveCommands[name] {
+		return []string{name, "(redacted)"}
+	}
+	n := len(args)
+	if n >= slowLogMaxArgs {
+		n = slowLogMaxArgs - 2
+	}
+	list := make([]string, 0, n+2)
+	list = append(list, name)
+	for _, arg := range args[:n] {
+		var val string
+		err := resp.ConvertFrom(arg, &val)
+		if err != nil {
+			val = arg.Format(0)
+		}
+		if len(val) > slowLogMaxArgLen {
+			val = fmt.Sprintf("%s... (%d more bytes)", val[:slowLogMaxArgLen], len(val)-slowLogMaxArgLen)
+		}
+		list = append(list, val)
+	}
+	if n != len(args) {
+		list = append(list, fmt.Sprintf("... (%d more arguments)", len(args)-n))
+	}
+	return list
+}
+
+// cmdSlowLog manages the slow log by SLOWLOG GET [count] | LEN | RESET.
+func (c *Commands) cmdSlowLog(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	var sub string
+	err := resp.ConvertFrom(args[0], &sub)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(sub) {
+	default:
+		return nil, fmt.Errorf("Error unknown subcommand '%s'", sub)
+	case "get":
+		count := 10
+		switch len(args) {
+		default:
+			return nil, ErrWrongNumberOfArguments
+		case 1:
+		case 2:
+			var n int64
+			err := resp.ConvertFrom(args[1], &n)
+			if err != nil || n < -1 {
+				return nil, fmt.Errorf("Error count should be greater than or equal to -1")
+			}
+			count = int(n)
+		}
+		entries := c.slowlog.Get(count)
+		list := make(resp.ReplyMultiBulk, 0, len(entries))
+		for _, entry := range entries {
+			cmd := make(resp.ReplyMultiBulk, 0, len(entry.Args))
+			for _, arg := range entry.Args {
+				cmd = append(cmd, resp.ReplyBulk(arg))
+			}
+			list = append(list, resp.ReplyMultiBulk{
+				resp.ReplyInteger(strconv.FormatUint(entry.ID, 10)),
+				resp.ReplyInteger(strconv.FormatInt(entry.Time.Unix(), 10)),
+				resp.ReplyInteger(strconv.FormatInt(int64(entry.Duration/time.Microsecond), 10)),
+				cmd,
+				resp.ReplyBulk(entry.Addr),
+				resp.ReplyBulk(entry.Name),
+			})
+		}
+		return list, nil
+	case "len":
+		if len(args) != 1 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		return resp.ReplyInteger(strconv.Itoa(c.slowlog.Len())), nil
+	case "reset":
+		if len(args) != 1 {
+			return nil, ErrWrongNumberOfArguments
+		}
+		c.slowlog.Reset()
+		return reply.OK, nil
+	}
+}
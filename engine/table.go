@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"github.com/wzshiming/resp"
+)
+
+// The categories of commands, the users of ACL are allowed to run commands by category.
+const (
+	CategoryRead  = "read"
+	CategoryWrite = "write"
+	CategoryAdmin = "admin"
+)
+
+// CommandInfo describes a command.
+type CommandInfo struct {
+	Category string   // One of the categories, or empty for the commands that any user can run
+	FirstKey int      // The position of the first key in the arguments that start with the name, 0 if there is no key
+	LastKey  int      // The position of the last key, negative numbers count from the end
+	Step     int      // The step between the keys
+	Arity    int      // The number of the arguments with the name, negative numbers are the minimum, 0 is not checked
+	Flags    []string // The flags besides the ones of the category, like pubsub, noauth for the commands run without AUTH
+	Summary  string   // What the command does
+}
+
+// CheckArity returns whether the number of args, which start with the name, matches the arity.
+func (i *CommandInfo) CheckArity(args []resp.Reply) bool {
+	switch {
+	case i.Arity > 0:
+		return len(args) == i.Arity
+	case i.Arity < 0:
+		return len(args) >= -i.Arity
+	}
+	return true
+}
+
+// AllFlags returns the flags of the command, with the one of the category.
+func (i *CommandInfo) AllFlags() []string {
+	flags := []string{}
+	switch i.Category {
+	case CategoryRead:
+		flags = append(flags, "readonly")
+	case CategoryWrite:
+		flags = append(flags, "write")
+	case CategoryAdmin:
+		flags = append(flags, "admin")
+	}
+	return append(flags, i.Flags...)
+}
+
+// HasFlag returns whether the command has the flag.
+func (i *CommandInfo) HasFlag(flag string) bool {
+	for _, f := range i.AllFlags() {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the keys in args, which start with the name.
+func (i *CommandInfo) Keys(args []resp.Reply) []resp.Reply {
+	if i.FirstKey <= 0 || i.FirstKey >= len(args) {
+		return nil
+	}
+	last := i.LastKey
+	if last < 0 {
+		last += len(args)
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	step := i.Step
+	if step <= 0 {
+		step = 1
+	}
+	keys := []resp.Reply{}
+	for j := i.FirstKey; j <= last; j += step {
+		keys = append(keys, args[j])
+	}
+	return keys
+}
+
+// commandTable describes the builtin commands, they are registered with the info by AddCommand,
+// the ones missing are taken as admin commands without keys.
+var commandTable = map[string]*CommandInfo{
+	"auth":    {"", 0, 0, 0, -2, []string{"noauth"}, "Authenticate the connection"},
+	"hello":   {"", 0, 0, 0, -1, []string{"noauth"}, "Switch the protocol and reply the properties of the connection"},
+	"echo":    {"", 0, 0, 0, 2, nil, "Reply the message"},
+	"ping":    {"", 0, 0, 0, -1, nil, "Reply PONG or the message"},
+	"quit":    {"", 0, 0, 0, -1, []string{"noauth"}, "Close the connection"},
+	"time":    {"", 0, 0, 0, 1, nil, "Reply the time of the server"},
+	"multi":   {"", 0, 0, 0, 1, nil, "Start a transaction"},
+	"exec":    {"", 0, 0, 0, 1, nil, "Run the commands queued after MULTI"},
+	"discard": {"", 0, 0, 0, 1, nil, "Discard the commands queued after MULTI"},
+	"unwatch": {"", 0, 0, 0, 1, nil, "Forget the watched keys"},
+	"command": {"", 0, 0, 0, -1, nil, "Describe the commands"},
+	"watch":   {CategoryRead, 1, -1, 1, -2, nil, "Watch keys to run EXEC only if they are not modified"},
+
+	"info":     {CategoryAdmin, 0, 0, 0, -1, nil, "Reply the statistics of the storage"},
+	"shutdown": {CategoryAdmin, 0, 0, 0, -1, nil, "Shut down the server"},
+	"acl":      {CategoryAdmin, 0, 0, 0, -2, nil, "Manage the users of ACL"},
+	"client":   {CategoryAdmin, 0, 0, 0, -2, nil, "Manage the connected clients"},
+	"slowlog":  {CategoryAdmin, 0, 0, 0, -2, nil, "Manage the log of the slow commands"},
+	"monitor":  {CategoryAdmin, 0, 0, 0, 1, nil, "Stream the commands run by the server"},
+	"config":   {CategoryAdmin, 0, 0, 0, -2, nil, "Get, set and rewrite the parameters of the server"},
+
+	"subscribe":    {CategoryRead, 0, 0, 0, -2, []string{"pubsub"}, "Listen for messages published to channels"},
+	"psubscribe":   {CategoryRead, 0, 0, 0, -2, []string{"pubsub"}, "Listen for messages published to channels matching patterns"},
+	"unsubscribe":  {CategoryRead, 0, 0, 0, -1, []string{"pubsub"}, "Stop listening to channels"},
+	"punsubscribe": {CategoryRead, 0, 0, 0, -1, []string{"pubsub"}, "Stop listening to patterns"},
+	"publish":      {CategoryWrite, 0, 0, 0, 3, []string{"pubsub"}, "Post a message to a channel"},
+
+	"get":      {CategoryRead, 1, 1, 1, 2, nil, "Get the value of a key"},
+	"set":      {CategoryWrite, 1, 1, 1, 3, nil, "Set the value of a key"},
+	"getset":   {CategoryWrite, 1, 1, 1, 3, nil, "Set the value of a key and return the old one"},
+	"del":      {CategoryWrite, 1, -1, 1, -2, nil, "Delete keys"},
+	"exists":   {CategoryRead, 1, -1, 1, -2, nil, "Count the keys that exist"},
+	"rename":   {CategoryWrite, 1, 2, 1, 3, nil, "Rename a key"},
+	"mset":     {CategoryWrite, 1, -1, 2, -3, nil, "Set the values of keys"},
+	"incr":     {CategoryWrite, 1, 1, 1, 2, nil, "Increment the integer value of a key by one"},
+	"incrby":   {CategoryWrite, 1, 1, 1, 3, nil, "Increment the integer value of a key"},
+	"getbit":   {CategoryRead, 1, 1, 1, 3, nil, "Get the bit at an offset of the value of a key"},
+	"setbit":   {CategoryWrite, 1, 1, 1, 4, nil, "Set the bit at an offset of the value of a key"},
+	"bitcount": {CategoryRead, 1, 1, 1, -2, nil, "Count the set bits of the value of a key"},
+	"append":   {CategoryWrite, 1, 1, 1, 3, nil, "Append to the value of a key"},
+	"strlen":   {CategoryRead, 1, 1, 1, 2, nil, "Get the length of the value of a key"},
+
+	"expire":  {CategoryWrite, 1, 1, 1, 3, nil, "Set the time to live of a key in seconds"},
+	"pexpire": {CategoryWrite, 1, 1, 1, 3, nil, "Set the time to live of a key in milliseconds"},
+	"ttl":     {CategoryRead, 1, 1, 1, 2, nil, "Get the time to live of a key in seconds"},
+	"pttl":    {CategoryRead, 1, 1, 1, 2, nil, "Get the time to live of a key in milliseconds"},
+	"persist": {CategoryWrite, 1, 1, 1, 2, nil, "Remove the expiration of a key"},
+	"setex":   {CategoryWrite, 1, 1, 1, 4, nil, "Set the value and the expiration of a key in seconds"},
+	"psetex":  {CategoryWrite, 1, 1, 1, 4, nil, "Set the value and the expiration of a key in milliseconds"},
+
+	"hset":    {CategoryWrite, 1, 1, 1, -4, nil, "Set fields of a hash"},
+	"hget":    {CategoryRead, 1, 1, 1, 3, nil, "Get a field of a hash"},
+	"hmget":   {CategoryRead, 1, 1, 1, -3, nil, "Get fields of a hash"},
+	"hgetall": {CategoryRead, 1, 1, 1, 2, nil, "Get all the fields and values of a hash"},
+	"hdel":    {CategoryWrite, 1, 1, 1, -3, nil, "Delete fields of a hash"},
+	"hlen":    {CategoryRead, 1, 1, 1, 2, nil, "Count the fields of a hash"},
+	"hscan":   {CategoryRead, 1, 1, 1, 5, nil, "Get the fields and values of a hash between two fields"},
+
+	"lpush":  {CategoryWrite, 1, 1, 1, -3, nil, "Prepend elements to a list"},
+	"rpush":  {CategoryWrite, 1, 1, 1, -3, nil, "Append elements to a list"},
+	"lpop":   {CategoryWrite, 1, 1, 1, 2, nil, "Remove and get the first element of a list"},
+	"rpop":   {CategoryWrite, 1, 1, 1, 2, nil, "Remove and get the last element of a list"},
+	"llen":   {CategoryRead, 1, 1, 1, 2, nil, "Get the length of a list"},
+	"lindex": {CategoryRead, 1, 1, 1, 3, nil, "Get an element of a list by index"},
+	"lrange": {CategoryRead, 1, 1, 1, 4, nil, "Get a range of elements of a list"},
+	"ltrim":  {CategoryWrite, 1, 1, 1, 4, nil, "Trim a list to a range"},
+
+	"zadd":             {CategoryWrite, 1, 1, 1, -4, nil, "Add members to a sorted set"},
+	"zincrby":          {CategoryWrite, 1, 1, 1, 4, nil, "Increment the score of a member of a sorted set"},
+	"zrem":             {CategoryWrite, 1, 1, 1, -3, nil, "Remove members of a sorted set"},
+	"zcard":            {CategoryRead, 1, 1, 1, 2, nil, "Count the members of a sorted set"},
+	"zscore":           {CategoryRead, 1, 1, 1, 3, nil, "Get the score of a member of a sorted set"},
+	"zrank":            {CategoryRead, 1, 1, 1, 3, nil, "Get the rank of a member of a sorted set"},
+	"zrevrank":         {CategoryRead, 1, 1, 1, 3, nil, "Get the rank of a member of a sorted set, from high to low scores"},
+	"zrange":           {CategoryRead, 1, 1, 1, -4, nil, "Get a range of members of a sorted set by index"},
+	"zrevrange":        {CategoryRead, 1, 1, 1, -4, nil, "Get a range of members of a sorted set by index, from high to low scores"},
+	"zrangebyscore":    {CategoryRead, 1, 1, 1, -4, nil, "Get the members of a sorted set in a range of scores"},
+	"zrevrangebyscore": {CategoryRead, 1, 1, 1, -4, nil, "Get the members of a sorted set in a range of scores, from high to low"},
+
+	"sadd":        {CategoryWrite, 1, 1, 1, -3, nil, "Add members to a set"},
+	"srem":        {CategoryWrite, 1, 1, 1, -3, nil, "Remove members of a set"},
+	"sismember":   {CategoryRead, 1, 1, 1, 3, nil, "Check if a member is in a set"},
+	"scard":       {CategoryRead, 1, 1, 1, 2, nil, "Count the members of a set"},
+	"smembers":    {CategoryRead, 1, 1, 1, 2, nil, "Get all the members of a set"},
+	"sinter":      {CategoryRead, 1, -1, 1, -2, nil, "Intersect sets"},
+	"sunion":      {CategoryRead, 1, -1, 1, -2, nil, "Union sets"},
+	"sdiff":       {CategoryRead, 1, -1, 1, -2, nil, "Subtract sets"},
+	"sinterstore": {CategoryWrite, 1, -1, 1, -3, nil, "Intersect sets and store the result in a key"},
+	"sunionstore": {CategoryWrite, 1, -1, 1, -3, nil, "Union sets and store the result in a key"},
+	"sdiffstore":  {CategoryWrite, 1, -1, 1, -3, nil, "Subtract sets and store the result in a key"},
+
+	// The keys are the bounds of the ranges, ACL allows a range only within a prefix allowed.
+	"keys":  {CategoryRead, 1, 2, 1, 4, []string{"range"}, "Get the keys between two keys"},
+	"rkeys": {CategoryRead, 1, 2, 1, 4, []string{"range"}, "Get the keys between two keys in reverse order"},
+	"scan":  {CategoryRead, 1, 2, 1, 4, []string{"range"}, "Get the string keys and values between two keys"},
+	"rscan": {CategoryRead, 1, 2, 1, 4, []string{"range"}, "Get the string keys and values between two keys in reverse order"},
+}
+
+// selfSubcommands are the subcommands of admin commands that only concern the connection itself,
+// any user can run them.
+var selfSubcommands = map[string]map[string]bool{
+	"acl":    {"whoami": true},
+	"client": {"id": true, "setname": true, "getname": true},
+}
+
+// unknownCommand is the info of the commands missing in the table.
+var unknownCommand = &CommandInfo{CategoryAdmin, 0, 0, 0, 0, nil, ""}
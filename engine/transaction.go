@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/reply"
+	"github.com/wzshiming/resp"
+)
+
+var (
+	ErrNestedMulti         = errors.New("Error MULTI calls can not be nested")
+	ErrExecWithoutMulti    = errors.New("Error EXEC without MULTI")
+	ErrDiscardWithoutMulti = errors.New("Error DISCARD without MULTI")
+	ErrWatchInsideMulti    = errors.New("Error WATCH inside MULTI is not allowed")
+	ErrExecAbort           = errors.New("EXECABORT Transaction discarded because of previous errors")
+	errWatchedKeyModified  = errors.New("Error watched key modified")
+)
+
+// Transactor runs fn inside one transaction of the storage,
+// the commands called by fn with the session are applied atomically, and none of them if fn fails.
+// The writes of a command that fails are undone by the storage, the other commands are still applied.
+type Transactor func(s *lrdb.Session, fn func() error) error
+
+// immediate are the commands that are not queued inside MULTI.
+var immediate = map[string]bool{
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+	"watch":   true,
+	"unwatch": true,
+	"quit":    true,
+	"auth":    true,
+}
+
+func (c *Commands) cmdMulti(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, ErrWrongNumberOfArguments
+	case 0:
+	}
+	if !s.Multi() {
+		return nil, ErrNestedMulti
+	}
+	return reply.OK, nil
+}
+
+func (c *Commands) cmdDiscard(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, ErrWrongNumberOfArguments
+	case 0:
+	}
+	if !s.InMulti() {
+		return nil, ErrDiscardWithoutMulti
+	}
+	s.Discard()
+	s.Unwatch()
+	return reply.OK, nil
+}
+
+func (c *Commands) cmdExec(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+	switch len(args) {
+	default:
+		return nil, ErrWrongNumberOfArguments
+	case 0:
+	}
+	if !s.InMulti() {
+		return nil, ErrExecWithoutMulti
+	}
+	queue, aborted := s.Exec()
+	if aborted {
+		s.Unwatch()
+		return nil, ErrExecAbort
+	}
+
+	results := make(resp.ReplyMultiBulk, 0, len(queue))
+	run := func() error {
+		// Checked inside the transaction, the watched keys can not be modified by others from here on.
+		if s.Dirty() {
+			return errWatchedKeyModified
+		}
+		s.Unwatch()
+		// Like Redis, the error of a command is replied in place of its result,
+		// only the errors found while queueing abort the transaction.
+		for _, args := range queue {
+			result, err := c.cmd(s, args)
+			if err != nil {
+				result = resp.ReplyError(err.Error())
+			}
+			results = append(results, result)
+		}
+		return nil
+	}
+
+	var err error
+	if c.transactor != nil {
+		err = c.transactor(s, run)
+	} else {
+		err = run()
+	}
+	if err != nil {
+		s.Unwatch()
+		if err == errWatchedKeyModified {
+			return resp.ReplyMultiBulk(nil), nil
+		}
+		return nil, fmt.Errorf("EXECABORT Transaction discarded because of: %s", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,367 @@
+package lrdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	leveldberrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/wzshiming/resp"
+)
+
+// httpMaxBody is the max size of the body of a request.
+const httpMaxBody = 64 * 1024 * 1024
+
+// httpReadTimeout is how long a request can take to be read, the idle connections are closed by the idle timeout.
+const httpReadTimeout = time.Minute
+
+// ListenHTTP serves the HTTP/JSON gateway on address, it's shut down with the server.
+func (db *LRDB) ListenHTTP(address string) error {
+	return db.ListenHTTPHandler(address, db)
+}
+
+// ListenHTTPHandler serves handler on address, it's shut down with the server like ListenHTTP.
+func (db *LRDB) ListenHTTPHandler(address string, handler http.Handler) error {
+	listen, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if !db.addListener(listen) {
+		listen.Close()
+		return ErrServerClosed
+	}
+	defer db.removeListener(listen)
+	db.logger.Log(LevelInfo, "Listen", F("addr", address), F("protocol", "http"))
+
+	db.mu.Lock()
+	idleTimeout := db.idleTimeout
+	db.mu.Unlock()
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadTimeout,
+		ReadTimeout:       httpReadTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	err = srv.Serve(listen)
+	if db.isClosing() {
+		<-db.done
+		srv.Close()
+		return ErrServerClosed
+	}
+	return err
+}
+
+// ServeHTTP serves the HTTP/JSON gateway, every request runs its commands in a new session.
+// The credentials of the basic authentication are used to AUTH the session.
+// The session can PUBLISH but not subscribe, pub/sub needs a connection of RESP.
+// The bodies of the requests are limited to httpMaxBody.
+//
+//	GET    /keys/{key}                  Get the value of the key in the raw bytes
+//	PUT    /keys/{key}?ttl={seconds}    Set the key to the body, expire it after ttl if it's given
+//	DELETE /keys/{key}                  Delete the key
+//	GET    /scan?start=&end=&limit=     The string keys and values between start and end, like SCAN
+//	POST   /cmd                         Run the command of the JSON array in the body, like ["hgetall","key"]
+//
+// The replies are converted to JSON, the errors are replied as {"error":"..."},
+// the strings that are not valid UTF-8 are replied as {"base64":"..."},
+// and the maps with such keys are replied as arrays of pairs, like [[{"base64":"..."},"..."]].
+func (db *LRDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !db.addRequest() {
+		httpError(w, http.StatusServiceUnavailable, ErrServerClosed.Error())
+		return
+	}
+	defer db.handlers.Done()
+
+	r.Body = http.MaxBytesReader(w, r.Body, httpMaxBody)
+	session := NewSession(db.broker, nil)
+	session.SetAddr(r.RemoteAddr)
+	defer session.Close()
+	if username, password, ok := r.BasicAuth(); ok {
+		args := []string{"auth", username, password}
+		if username == "" {
+			args = []string{"auth", password}
+		}
+		_, err := db.httpCmd(session, args)
+		if err != nil {
+			httpError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+	}
+
+	var args []string
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/keys/"):
+		key := strings.TrimPrefix(r.URL.Path, "/keys/")
+		switch r.Method {
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			httpError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		case http.MethodGet:
+			result, err := db.httpCmd(session, []string{"get", key})
+			if err != nil {
+				if isNotFound(err) {
+					httpError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+					return
+				}
+				httpError(w, httpStatus(err), err.Error())
+				return
+			}
+			value, _ := result.(resp.ReplyBulk)
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(value)
+			return
+		case http.MethodPut:
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				httpError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+				args = []string{"setex", key, ttl, string(body)}
+			} else {
+				args = []string{"set", key, string(body)}
+			}
+		case http.MethodDelete:
+			args = []string{"del", key}
+		}
+	case r.URL.Path == "/scan":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			httpError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		query := r.URL.Query()
+		limit := query.Get("limit")
+		if limit == "" {
+			limit = "100"
+		}
+		args = []string{"scan", query.Get("start"), query.Get("end"), limit}
+	case r.URL.Path == "/cmd":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			httpError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		var err error
+		args, err = decodeArgs(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		httpError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	result, err := db.httpCmd(session, args)
+	if err != nil {
+		httpError(w, httpStatus(err), err.Error())
+		return
+	}
+	buf := bytes.NewBuffer(nil)
+	replyJSON(buf, result)
+	buf.WriteByte('\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+// addRequest tracks an HTTP request like a connection, so Shutdown waits for it.
+func (db *LRDB) addRequest() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closing {
+		return false
+	}
+	db.handlers.Add(1)
+	return true
+}
+
+// httpCmd runs the command in the session, the error replies are returned as errors.
+func (db *LRDB) httpCmd(session *Session, args []string) (resp.Reply, error) {
+	req, err := resp.ConvertTo(args)
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.engine.Cmd(session, req)
+	switch err {
+	case nil:
+	case ErrQuit:
+	case ErrShutdown:
+		go db.shutdown()
+		return resp.ReplyStatus("OK"), nil
+	default:
+		return nil, err
+	}
+	if e, ok := result.(resp.ReplyError); ok {
+		return nil, httpErr(e)
+	}
+	return result, nil
+}
+
+type httpErr string
+
+func (e httpErr) Error() string {
+	return string(e)
+}
+
+// decodeArgs decodes the command in the body, which is a JSON array of strings and numbers.
+func decodeArgs(r *http.Request) ([]string, error) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	var raw []interface{}
+	err := decoder.Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, httpErr("Error empty command")
+	}
+	args := make([]string, 0, len(raw))
+	for _, arg := range raw {
+		switch t := arg.(type) {
+		default:
+			return nil, httpErr("Error the arguments must be strings or numbers")
+		case string:
+			args = append(args, t)
+		case json.Number:
+			args = append(args, t.String())
+		}
+	}
+	return args, nil
+}
+
+// isNotFound returns if the error of a command is that the key doesn't exist, like the one of GET.
+func isNotFound(err error) bool {
+	return err == leveldberrors.ErrNotFound
+}
+
+// httpStatus returns the status code of the error of a command.
+func httpStatus(err error) int {
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "NOAUTH"), strings.HasPrefix(msg, "WRONGPASS"):
+		return http.StatusUnauthorized
+	case strings.HasPrefix(msg, "NOPERM"):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	buf := bytes.NewBuffer(nil)
+	replyJSON(buf, resp.ReplyError(msg))
+	buf.WriteByte('\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// replyJSON writes r in JSON, the maps are objects with the keys in order,
+// the nil bulk strings and arrays are null, the errors are {"error":"..."},
+// the bulk strings that are not valid UTF-8 are {"base64":"..."}, and the maps with such keys are arrays of pairs.
+func replyJSON(buf *bytes.Buffer, r resp.Reply) {
+	switch t := r.(type) {
+	default:
+		buf.WriteString("null")
+	case resp.ReplyBulk:
+		if t == nil {
+			buf.WriteString("null")
+			return
+		}
+		if !utf8.Valid(t) {
+			buf.WriteString(`{"base64":`)
+			jsonString(buf, base64.StdEncoding.EncodeToString(t))
+			buf.WriteByte('}')
+			return
+		}
+		jsonString(buf, string(t))
+	case resp.ReplyStatus:
+		jsonString(buf, string(t))
+	case resp.ReplyInteger:
+		buf.Write(t)
+	case resp.ReplyError:
+		buf.WriteString(`{"error":`)
+		jsonString(buf, string(t))
+		buf.WriteByte('}')
+	case Double:
+		f, err := strconv.ParseFloat(string(t.ReplyBulk), 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			jsonString(buf, string(t.ReplyBulk))
+			return
+		}
+		buf.Write(t.ReplyBulk)
+	case resp.ReplyMultiBulk:
+		if t == nil {
+			buf.WriteString("null")
+			return
+		}
+		jsonArray(buf, t)
+	case Set:
+		jsonArray(buf, t.ReplyMultiBulk)
+	case Push:
+		jsonArray(buf, t.ReplyMultiBulk)
+	case Replies:
+		jsonArray(buf, t.ReplyMultiBulk)
+	case Map:
+		// The keys of objects are strings, a map with a key that is not valid UTF-8 is an array of pairs.
+		if !validKeys(t.ReplyMultiBulk) {
+			buf.WriteByte('[')
+			for i := 0; i+1 < len(t.ReplyMultiBulk); i += 2 {
+				if i != 0 {
+					buf.WriteByte(',')
+				}
+				jsonArray(buf, t.ReplyMultiBulk[i:i+2])
+			}
+			buf.WriteByte(']')
+			return
+		}
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(t.ReplyMultiBulk); i += 2 {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			var key string
+			resp.ConvertFrom(t.ReplyMultiBulk[i], &key)
+			jsonString(buf, key)
+			buf.WriteByte(':')
+			replyJSON(buf, t.ReplyMultiBulk[i+1])
+		}
+		buf.WriteByte('}')
+	}
+}
+
+// validKeys returns if the keys of the pairs are valid UTF-8.
+func validKeys(pairs resp.ReplyMultiBulk) bool {
+	for i := 0; i < len(pairs); i += 2 {
+		var key []byte
+		if resp.ConvertFrom(pairs[i], &key) == nil && !utf8.Valid(key) {
+			return false
+		}
+	}
+	return true
+}
+
+func jsonArray(buf *bytes.Buffer, rs resp.ReplyMultiBulk) {
+	buf.WriteByte('[')
+	for i, r := range rs {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		replyJSON(buf, r)
+	}
+	buf.WriteByte(']')
+}
+
+func jsonString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	buf.Write(data)
+}
@@ -0,0 +1,217 @@
+package lrdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// The levels of log entries, from the most verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a level, like info.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Error invalid log level '%s'", name)
+}
+
+// The formats of LevelLogger.
+const (
+	LogText = "text" // Like [LRDB] 2006/01/02 15:04:05 INFO Join addr=127.0.0.1:52555
+	LogJSON = "json" // A JSON object per line, with time, level, msg and the fields
+)
+
+// Field is a key and value of a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a field.
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+// Logger writes the log entries of the server.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// LevelLogger is a Logger that writes the entries of its level and above.
+type LevelLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+	level  Level
+	format string
+}
+
+// NewLevelLogger returns a logger that writes the entries of level and above to writer in format.
+func NewLevelLogger(writer io.Writer, level Level, format string) (*LevelLogger, error) {
+	switch format {
+	default:
+		return nil, fmt.Errorf("Error invalid log format '%s'", format)
+	case LogText, LogJSON:
+	}
+	return &LevelLogger{
+		writer: writer,
+		level:  level,
+		format: format,
+	}, nil
+}
+
+// SetLevel sets the min level of the entries written.
+func (l *LevelLogger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// Enabled returns if the entries of level are written.
+func (l *LevelLogger) Enabled(level Level) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level >= l.level
+}
+
+func (l *LevelLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := time.Now()
+	buf := bytes.NewBuffer(nil)
+	if l.format == LogJSON {
+		buf.WriteString(`{"time":`)
+		jsonValue(buf, now.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		jsonValue(buf, level.String())
+		buf.WriteString(`,"msg":`)
+		jsonValue(buf, msg)
+		for _, field := range fields {
+			buf.WriteByte(',')
+			jsonValue(buf, field.Key)
+			buf.WriteByte(':')
+			jsonValue(buf, fieldValue(field.Value))
+		}
+		buf.WriteString("}\n")
+	} else {
+		buf.WriteString("[LRDB] ")
+		buf.WriteString(now.Format("2006/01/02 15:04:05 "))
+		buf.WriteString(strings.ToUpper(level.String()))
+		buf.WriteByte(' ')
+		buf.WriteString(msg)
+		for _, field := range fields {
+			buf.WriteByte(' ')
+			buf.WriteString(field.Key)
+			buf.WriteByte('=')
+			val := fmt.Sprint(fieldValue(field.Value))
+			if val == "" || strings.ContainsAny(val, " \t\r\n\"=") {
+				val = strconv.Quote(val)
+			}
+			buf.WriteString(val)
+		}
+		buf.WriteByte('\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writer.Write(buf.Bytes())
+}
+
+// fieldValue converts the values that have no useful JSON, like errors and durations, to strings.
+func fieldValue(val interface{}) interface{} {
+	switch t := val.(type) {
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	case fmt.Stringer:
+		return t.String()
+	}
+	return val
+}
+
+func jsonValue(buf *bytes.Buffer, val interface{}) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(val))
+	}
+	buf.Write(data)
+}
+
+// NopLogger discards all log entries.
+type NopLogger struct{}
+
+func (NopLogger) Log(level Level, msg string, fields ...Field) {}
+
+// LogFile is a log file that can be reopened, for the rotation of logs by tools like logrotate.
+type LogFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenLogFile opens the file at path for appending, it's created if it does not exist.
+func OpenLogFile(path string) (*LogFile, error) {
+	f := &LogFile{
+		path: path,
+	}
+	err := f.Reopen()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reopen closes the file and opens the path again, which is a new file after it's moved.
+func (f *LogFile) Reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	return nil
+}
+
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Write(p)
+}
+
+func (f *LogFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
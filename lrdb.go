@@ -1,73 +1,401 @@
 package lrdb
 
 import (
+	"context"
 	"errors"
-	"log"
+	"io"
 	"net"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/wzshiming/resp"
 )
 
-var ErrQuit = errors.New("Quit")
+var (
+	ErrQuit         = errors.New("Quit")
+	ErrShutdown     = errors.New("Shutdown")
+	ErrServerClosed = errors.New("Server closed")
+)
+
+// pushBuffer is the number of messages buffered for a connection in the subscriber mode,
+// the connection is closed when it can't keep up and the buffer is full.
+const pushBuffer = 1024
 
 type LRDB struct {
-	engine Engine
-	logger *log.Logger
+	engine  Engine
+	broker  *Broker
+	clients *Registry
+	logger  Logger
+
+	mu              sync.Mutex
+	listeners       map[net.Listener]struct{}
+	conns           map[net.Conn]struct{}
+	handlers        sync.WaitGroup
+	closing         bool
+	done            chan struct{}
+	shutdownTimeout time.Duration
+	idleTimeout     time.Duration
 }
 
 func NewLRDB(engine Engine) *LRDB {
 	return &LRDB{
-		engine: engine,
-		logger: log.New(os.Stdout, "[LRDB] ", log.LstdFlags),
+		engine:          engine,
+		broker:          NewBroker(),
+		clients:         NewRegistry(),
+		logger:          defaultLogger(),
+		listeners:       map[net.Listener]struct{}{},
+		conns:           map[net.Conn]struct{}{},
+		done:            make(chan struct{}),
+		shutdownTimeout: 10 * time.Second,
 	}
 }
 
+func defaultLogger() Logger {
+	logger, _ := NewLevelLogger(os.Stdout, LevelInfo, LogText)
+	return logger
+}
+
+// Logger returns the logger of the server.
+func (db *LRDB) Logger() Logger {
+	return db.logger
+}
+
+// SetLogger sets the logger of the server, it must be set before serving, nil discards the logs.
+func (db *LRDB) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	db.logger = logger
+}
+
+// Broker returns the broker of pub/sub, messages can be published and subscribed in process with it.
+func (db *LRDB) Broker() *Broker {
+	return db.broker
+}
+
+// SetShutdownTimeout sets how long the SHUTDOWN command waits for the connections to drain.
+func (db *LRDB) SetShutdownTimeout(d time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.shutdownTimeout = d
+}
+
+// Clients returns the registry of the connected clients.
+func (db *LRDB) Clients() *Registry {
+	return db.clients
+}
+
+// SetMaxClients sets the max number of connected clients, the others are rejected with ErrMaxClients.
+// 0 means no limit.
+func (db *LRDB) SetMaxClients(max int) {
+	db.clients.SetMax(max)
+}
+
+// SetIdleTimeout sets how long a client can be idle before it's disconnected, 0 means never.
+// The clients in the subscriber mode are never disconnected for being idle.
+func (db *LRDB) SetIdleTimeout(d time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.idleTimeout = d
+}
+
 func (db *LRDB) Listen(address string) error {
 	listen, err := net.Listen("tcp", address)
 	if err != nil {
 		return err
 	}
-	db.logger.Println("Listen", address)
+	db.logger.Log(LevelInfo, "Listen", F("addr", address))
+	return db.Serve(context.Background(), listen)
+}
+
+// Serve accepts connections on listener until ctx is done or Shutdown is called.
+// After Shutdown it returns ErrServerClosed once the server is shut down,
+// otherwise it returns the error of ctx or the listener, and the listener is closed.
+func (db *LRDB) Serve(ctx context.Context, listener net.Listener) error {
+	return db.serve(ctx, listener, db.Handle)
+}
+
+// serve accepts connections on listener and handles them with handle.
+func (db *LRDB) serve(ctx context.Context, listener net.Listener, handle func(net.Conn) error) error {
+	if !db.addListener(listener) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	defer db.removeListener(listener)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-stop:
+		}
+	}()
+
+	var delay time.Duration
 	for {
-		conn, err := listen.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			db.logger.Println(err)
+			if db.isClosing() {
+				<-db.done
+				return ErrServerClosed
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// Back off on errors like running out of file descriptors.
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				db.logger.Log(LevelWarn, "Accept", F("error", err), F("retry", delay))
+				time.Sleep(delay)
+				continue
+			}
+			listener.Close()
+			return err
+		}
+		delay = 0
+		if !db.addConn(conn) {
+			conn.Close()
 			continue
 		}
-		go db.Handle(conn)
+		go func() {
+			defer db.removeConn(conn)
+			handle(conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections, waits for the connections to finish the commands in flight,
+// and then closes the engine if it's an io.Closer.
+// The connections still running when ctx is done are closed, and the error of ctx is returned.
+func (db *LRDB) Shutdown(ctx context.Context) error {
+	db.mu.Lock()
+	if db.closing {
+		db.mu.Unlock()
+		return ErrServerClosed
+	}
+	db.closing = true
+	for listener := range db.listeners {
+		listener.Close()
+	}
+	// The connections quit when they read the next command.
+	for conn := range db.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	db.mu.Unlock()
+	defer close(db.done)
+	db.logger.Log(LevelInfo, "Shutdown")
+
+	drained := make(chan struct{})
+	go func() {
+		db.handlers.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		db.mu.Lock()
+		for conn := range db.conns {
+			conn.Close()
+		}
+		db.mu.Unlock()
+	}
+
+	if closer, ok := db.engine.(io.Closer); ok {
+		e := closer.Close()
+		if err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (db *LRDB) isClosing() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.closing
+}
+
+func (db *LRDB) addListener(listener net.Listener) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closing {
+		return false
+	}
+	db.listeners[listener] = struct{}{}
+	return true
+}
+
+func (db *LRDB) removeListener(listener net.Listener) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.listeners, listener)
+}
+
+func (db *LRDB) addConn(conn net.Conn) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closing {
+		return false
 	}
+	db.conns[conn] = struct{}{}
+	db.handlers.Add(1)
+	return true
+}
+
+func (db *LRDB) removeConn(conn net.Conn) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.conns, conn)
+	db.handlers.Done()
+}
+
+// shutdown runs Shutdown for the SHUTDOWN command.
+func (db *LRDB) shutdown() {
+	db.mu.Lock()
+	timeout := db.shutdownTimeout
+	db.mu.Unlock()
 
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := db.Shutdown(ctx)
+	if err != nil && err != ErrServerClosed {
+		db.logger.Log(LevelError, "Shutdown", F("error", err))
+	}
 }
 
 func (db *LRDB) Handle(conn net.Conn) error {
-	decoder := resp.NewDecoder(conn)
-	encoder := resp.NewEncoder(conn)
 	defer conn.Close()
 	addr := conn.RemoteAddr()
-	db.logger.Println("Join", addr)
+	client, err := db.clients.Add(conn)
+	if err != nil {
+		db.logger.Log(LevelWarn, "Reject", F("addr", addr), F("error", err))
+		NewEncoder(conn).Encode(resp.ReplyError(err.Error()))
+		return err
+	}
+	defer db.clients.Remove(client)
+	conn = client.Conn()
+
+	decoder := NewDecoder(conn)
+	encoder := NewEncoder(conn)
+	db.logger.Log(LevelDebug, "Join", F("addr", addr))
+	subscriber := NewSubscriber(pushBuffer)
+	session := NewSession(db.broker, subscriber)
+	session.SetClient(client)
+	defer session.Close()
+
+	// The messages are pushed while commands are read, the writes are serialized by mu,
+	// which is held while a command runs, so its replies are not interleaved with the messages.
+	var mu sync.Mutex
+	go func() {
+		for msg := range subscriber.C() {
+			mu.Lock()
+			err := encoder.Encode(msg.Reply())
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+		// The client can't keep up with the messages, it's disconnected like Redis does.
+		if subscriber.Overflowed() {
+			db.logger.Log(LevelWarn, "Quit", F("addr", addr), F("reason", "push buffer full"))
+			conn.Close()
+		}
+	}()
+
 	for {
+		if !db.setIdleDeadline(conn, session.Subscribed() || session.Monitoring()) {
+			db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("reason", "shutdown"))
+			return nil
+		}
 		reply, err := decoder.Decode()
+		if err == ErrUnbalancedQuotes {
+			mu.Lock()
+			err = encoder.Encode(resp.ReplyError(err.Error()))
+			mu.Unlock()
+			if err != nil {
+				db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("error", err))
+				return err
+			}
+			continue
+		}
 		if err != nil {
-			db.logger.Println("Quit", addr, err)
+			if db.isClosing() {
+				db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("reason", "shutdown"))
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("reason", "idle timeout"))
+				return nil
+			}
+			db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("error", err))
 			return err
 		}
+		client.Touch(commandName(reply))
 
-		result, err := db.engine.Cmd(reply)
+		mu.Lock()
+		result, err := db.engine.Cmd(session, reply)
 		if err != nil {
-			if err == ErrQuit {
-				db.logger.Println("Quit", addr)
+			switch err {
+			case ErrQuit:
+				db.logger.Log(LevelDebug, "Quit", F("addr", addr))
 				encoder.Encode(result)
+				mu.Unlock()
+				return nil
+			case ErrShutdown:
+				db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("reason", "shutdown"))
+				mu.Unlock()
+				go db.shutdown()
 				return nil
 			}
 			result = resp.ReplyError(err.Error())
 		}
 
+		encoder.SetProtocol(session.Protocol())
 		err = encoder.Encode(result)
+		mu.Unlock()
 		if err != nil {
-			db.logger.Println("Quit", addr, err)
+			db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("error", err))
 			return err
 		}
 	}
 }
+
+// setIdleDeadline sets the read deadline of the next command by the idle timeout, unless keep is true,
+// it returns false if the server is shutting down, whose deadline must not be overridden.
+func (db *LRDB) setIdleDeadline(conn net.Conn, keep bool) bool {
+	db.mu.Lock()
+	timeout := db.idleTimeout
+	db.mu.Unlock()
+	if timeout > 0 {
+		if keep {
+			conn.SetReadDeadline(time.Time{})
+		} else {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+	}
+	return !db.isClosing()
+}
+
+// commandName returns the lowercase name of the command in r.
+func commandName(r resp.Reply) string {
+	mb, ok := r.(resp.ReplyMultiBulk)
+	if !ok || len(mb) == 0 {
+		return ""
+	}
+	b, ok := mb[0].(resp.ReplyBulk)
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(string(b))
+}
@@ -0,0 +1,91 @@
+package lrdb
+
+// Match reports whether name matches the glob pattern.
+// '*' matches any sequence of bytes, '?' matches any single byte,
+// '[...]' matches a byte in the class, which may be negated with '^' and contain ranges like 'a-z',
+// and '\' escapes the next byte.
+func Match(pattern, name string) bool {
+	for len(pattern) != 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if Match(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+			name = name[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(name) == 0 {
+				return false
+			}
+			n, ok := matchClass(pattern[1:], name[0])
+			if !ok {
+				return false
+			}
+			name = name[1:]
+			pattern = pattern[1+n:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+			name = name[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(name) == 0
+}
+
+// matchClass matches b against the class that pattern starts with after the '[',
+// it returns the length of the class including the closing ']'.
+func matchClass(pattern string, b byte) (int, bool) {
+	i := 0
+	not := false
+	if i < len(pattern) && pattern[i] == '^' {
+		not = true
+		i++
+	}
+	match := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			if pattern[i] == b {
+				match = true
+			}
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= b && b <= hi {
+				match = true
+			}
+			i += 2
+		default:
+			if pattern[i] == b {
+				match = true
+			}
+		}
+	}
+	if i < len(pattern) {
+		i++
+	}
+	return i, match != not
+}
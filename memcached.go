@@ -0,0 +1,357 @@
+package lrdb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The errors of MemcachedStore.
+var (
+	ErrNotStored  = errors.New("Error not stored")
+	ErrExists     = errors.New("Error modified since it was fetched")
+	ErrNotFound   = errors.New("Error not found")
+	ErrNonNumeric = errors.New("Error cannot increment or decrement non-numeric value")
+)
+
+// The modes of storing an item of memcached.
+const (
+	MemcachedSet     = "set"     // Store the item
+	MemcachedAdd     = "add"     // Store the item only if the key does not exist
+	MemcachedReplace = "replace" // Store the item only if the key exists
+	MemcachedAppend  = "append"  // Add the value after the existing one, the flags and expiration are kept
+	MemcachedPrepend = "prepend" // Add the value before the existing one, the flags and expiration are kept
+	MemcachedCAS     = "cas"     // Store the item only if it's not modified since the CAS of the item is fetched
+)
+
+// MemcachedItem is an item of memcached.
+type MemcachedItem struct {
+	Key    []byte
+	Value  []byte
+	Flags  uint32
+	Expire int64  // Unix milliseconds, 0 means the item never expires
+	CAS    uint64 // The version of the item, which changes on every write
+}
+
+// MemcachedStore is the storage of the memcached protocol.
+type MemcachedStore interface {
+	// Get returns the items of keys, the missing ones are skipped.
+	Get(keys ...[]byte) ([]*MemcachedItem, error)
+	// Store stores item in mode, ErrNotStored, ErrNotFound or ErrExists are returned
+	// if the condition of mode is not met.
+	Store(mode string, item *MemcachedItem) error
+	// Delete removes key, ErrNotFound is returned if it does not exist.
+	Delete(key []byte) error
+	// Incr adds delta to the decimal value of key, or subtracts it if decr is true,
+	// the result wraps around on overflow, and stops at 0 on underflow.
+	Incr(key []byte, delta uint64, decr bool) (uint64, error)
+	// Touch changes the expiration of key.
+	Touch(key []byte, expire int64) error
+}
+
+const (
+	// maxMemcachedLine is the max length of a command line.
+	maxMemcachedLine = 2048
+	// maxMemcachedKey is the max length of a key.
+	maxMemcachedKey = 250
+	// maxMemcachedItem is the max size of a value.
+	maxMemcachedItem = 1024 * 1024
+	// memcachedRelative is the max expiration time in seconds that is relative to now,
+	// the greater ones are Unix timestamps.
+	memcachedRelative = 60 * 60 * 24 * 30
+)
+
+// errMemcachedFormat is the error of a bad command line.
+var errMemcachedFormat = errors.New("bad command line format")
+
+// ListenMemcached serves the memcached text protocol with store on address.
+// The protocol has no authentication, the commands skip the ACL, the rate limiter and MONITOR.
+func (db *LRDB) ListenMemcached(address string, store MemcachedStore) error {
+	listen, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	db.logger.Log(LevelInfo, "Listen", F("addr", address), F("protocol", "memcached"))
+	return db.ServeMemcached(context.Background(), listen, store)
+}
+
+// ServeMemcached is like Serve, but the connections speak the memcached text protocol with store.
+func (db *LRDB) ServeMemcached(ctx context.Context, listener net.Listener, store MemcachedStore) error {
+	return db.serve(ctx, listener, func(conn net.Conn) error {
+		return db.HandleMemcached(conn, store)
+	})
+}
+
+// HandleMemcached serves the memcached text protocol on conn.
+func (db *LRDB) HandleMemcached(conn net.Conn, store MemcachedStore) error {
+	defer conn.Close()
+	addr := conn.RemoteAddr()
+	client, err := db.clients.Add(conn)
+	if err != nil {
+		db.logger.Log(LevelWarn, "Reject", F("addr", addr), F("error", err))
+		io.WriteString(conn, "SERVER_ERROR "+err.Error()+"\r\n")
+		return err
+	}
+	defer db.clients.Remove(client)
+	conn = client.Conn()
+
+	db.logger.Log(LevelDebug, "Join", F("addr", addr), F("protocol", "memcached"))
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		if !db.setIdleDeadline(conn, false) {
+			db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("reason", "shutdown"))
+			return nil
+		}
+		line, err := readMemcachedLine(reader)
+		if err != nil {
+			if db.isClosing() {
+				db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("reason", "shutdown"))
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("reason", "idle timeout"))
+				return nil
+			}
+			if err == errMemcachedFormat {
+				writer.WriteString("CLIENT_ERROR line too long\r\n")
+				writer.Flush()
+			}
+			db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("error", err))
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			writer.WriteString("ERROR\r\n")
+			writer.Flush()
+			continue
+		}
+		client.Touch(fields[0])
+		if fields[0] == "quit" {
+			db.logger.Log(LevelDebug, "Quit", F("addr", addr))
+			return nil
+		}
+
+		err = memcachedCmd(reader, writer, store, fields)
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			db.logger.Log(LevelDebug, "Quit", F("addr", addr), F("error", err))
+			return err
+		}
+	}
+}
+
+func readMemcachedLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		data, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, data...)
+		if len(line) > maxMemcachedLine {
+			return "", errMemcachedFormat
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// memcachedCmd runs the command of fields, it returns only the errors of the connection.
+func memcachedCmd(reader *bufio.Reader, writer *bufio.Writer, store MemcachedStore, fields []string) error {
+	name, args := fields[0], fields[1:]
+	noreply := len(args) != 0 && args[len(args)-1] == "noreply"
+	reply := func(msg string) {
+		if !noreply {
+			writer.WriteString(msg)
+			writer.WriteString("\r\n")
+		}
+	}
+	fail := func(err error) {
+		switch err {
+		case ErrNotStored:
+			reply("NOT_STORED")
+		case ErrExists:
+			reply("EXISTS")
+		case ErrNotFound:
+			reply("NOT_FOUND")
+		case ErrNonNumeric:
+			reply("CLIENT_ERROR cannot increment or decrement non-numeric value")
+		case errMemcachedFormat:
+			reply("CLIENT_ERROR " + err.Error())
+		default:
+			reply("SERVER_ERROR " + err.Error())
+		}
+	}
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	for _, arg := range fields[1:] {
+		if len(arg) > maxMemcachedKey {
+			fail(errMemcachedFormat)
+			return nil
+		}
+	}
+
+	switch name {
+	default:
+		writer.WriteString("ERROR\r\n")
+	case "version":
+		writer.WriteString("VERSION lrdb\r\n")
+	case "get", "gets":
+		// Only the keys follow get, so noreply is a key.
+		keys := fields[1:]
+		if len(keys) == 0 {
+			writer.WriteString("ERROR\r\n")
+			return nil
+		}
+		bkeys := make([][]byte, 0, len(keys))
+		for _, key := range keys {
+			bkeys = append(bkeys, []byte(key))
+		}
+		items, err := store.Get(bkeys...)
+		if err != nil {
+			writer.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+			return nil
+		}
+		for _, item := range items {
+			writer.WriteString("VALUE ")
+			writer.Write(item.Key)
+			writer.WriteString(" " + strconv.FormatUint(uint64(item.Flags), 10))
+			writer.WriteString(" " + strconv.Itoa(len(item.Value)))
+			if name == "gets" {
+				writer.WriteString(" " + strconv.FormatUint(item.CAS, 10))
+			}
+			writer.WriteString("\r\n")
+			writer.Write(item.Value)
+			writer.WriteString("\r\n")
+		}
+		writer.WriteString("END\r\n")
+	case MemcachedSet, MemcachedAdd, MemcachedReplace, MemcachedAppend, MemcachedPrepend, MemcachedCAS:
+		size := 4
+		if name == MemcachedCAS {
+			size = 5
+		}
+		if len(args) != size {
+			writer.WriteString("ERROR\r\n")
+			return nil
+		}
+		flags, err1 := strconv.ParseUint(args[1], 10, 32)
+		exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+		length, err3 := strconv.ParseInt(args[3], 10, 32)
+		if err1 != nil || err2 != nil || err3 != nil || length < 0 {
+			fail(errMemcachedFormat)
+			return nil
+		}
+		item := &MemcachedItem{
+			Key:    []byte(args[0]),
+			Flags:  uint32(flags),
+			Expire: memcachedExpire(exptime),
+		}
+		if name == MemcachedCAS {
+			item.CAS, err1 = strconv.ParseUint(args[4], 10, 64)
+			if err1 != nil {
+				fail(errMemcachedFormat)
+				return nil
+			}
+		}
+
+		if length > maxMemcachedItem {
+			_, err := io.CopyN(ioutil.Discard, reader, length+2)
+			if err != nil {
+				return err
+			}
+			reply("SERVER_ERROR object too large for cache")
+			return nil
+		}
+		data := make([]byte, length+2)
+		_, err := io.ReadFull(reader, data)
+		if err != nil {
+			return err
+		}
+		if data[length] != '\r' || data[length+1] != '\n' {
+			// Skip the rest of the data line like memcached.
+			if data[length+1] != '\n' {
+				readMemcachedLine(reader)
+			}
+			reply("CLIENT_ERROR bad data chunk")
+			return nil
+		}
+		item.Value = data[:length]
+
+		err = store.Store(name, item)
+		if err != nil {
+			fail(err)
+			return nil
+		}
+		reply("STORED")
+	case "delete":
+		if len(args) != 1 {
+			writer.WriteString("ERROR\r\n")
+			return nil
+		}
+		err := store.Delete([]byte(args[0]))
+		if err != nil {
+			fail(err)
+			return nil
+		}
+		reply("DELETED")
+	case "incr", "decr":
+		if len(args) != 2 {
+			writer.WriteString("ERROR\r\n")
+			return nil
+		}
+		delta, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			reply("CLIENT_ERROR invalid numeric delta argument")
+			return nil
+		}
+		val, err := store.Incr([]byte(args[0]), delta, name == "decr")
+		if err != nil {
+			fail(err)
+			return nil
+		}
+		reply(strconv.FormatUint(val, 10))
+	case "touch":
+		if len(args) != 2 {
+			writer.WriteString("ERROR\r\n")
+			return nil
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fail(errMemcachedFormat)
+			return nil
+		}
+		err = store.Touch([]byte(args[0]), memcachedExpire(exptime))
+		if err != nil {
+			fail(err)
+			return nil
+		}
+		reply("TOUCHED")
+	}
+	return nil
+}
+
+// memcachedExpire converts the expiration time of memcached to Unix milliseconds.
+// It's seconds from now if it's up to 30 days, otherwise a Unix timestamp,
+// 0 means never, and the negative ones are already expired.
+func memcachedExpire(exptime int64) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return 1
+	case exptime <= memcachedRelative:
+		return time.Now().Add(time.Duration(exptime)*time.Second).UnixNano() / int64(time.Millisecond)
+	}
+	return exptime * 1000
+}
@@ -0,0 +1,259 @@
+// Package metrics exports the metrics of the server in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/wzshiming/lrdb"
+	"github.com/wzshiming/lrdb/engine"
+	"github.com/wzshiming/resp"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Buckets are the upper bounds in seconds of the buckets of the latency histograms.
+var Buckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// unknownCommand is the label of the commands that are not added, so they don't add series.
+const unknownCommand = "unknown"
+
+type commandMetrics struct {
+	calls   uint64
+	errors  uint64
+	buckets []uint64 // Not cumulative, the last one is +Inf
+	sum     float64
+}
+
+// Metrics collects the metrics of the commands, and exports them with the ones of the server and LevelDB.
+type Metrics struct {
+	mu       sync.Mutex
+	commands map[string]*commandMetrics
+	known    func(name string) bool
+	server   *lrdb.LRDB
+	dbStats  func(stats *leveldb.DBStats) error
+}
+
+// NewMetrics returns the metrics without any command run.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		commands: map[string]*commandMetrics{},
+	}
+}
+
+// Instrument collects the metrics of the commands run by commands.
+func (m *Metrics) Instrument(commands *engine.Commands) {
+	m.mu.Lock()
+	m.known = func(name string) bool {
+		_, ok := commands.Info(name)
+		return ok
+	}
+	m.mu.Unlock()
+	commands.Use(engine.Timing(func(s *lrdb.Session, name string, args []resp.Reply, d time.Duration, err error) {
+		m.Observe(name, d, err)
+	}))
+}
+
+// SetServer exports the connection metrics of server.
+func (m *Metrics) SetServer(server *lrdb.LRDB) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.server = server
+}
+
+// SetDBStats exports the statistics of LevelDB populated by dbStats.
+func (m *Metrics) SetDBStats(dbStats func(stats *leveldb.DBStats) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dbStats = dbStats
+}
+
+// Observe records a command that took d, and failed if err is not nil.
+func (m *Metrics) Observe(name string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.known != nil && !m.known(name) {
+		name = unknownCommand
+	}
+	cm := m.commands[name]
+	if cm == nil {
+		cm = &commandMetrics{
+			buckets: make([]uint64, len(Buckets)+1),
+		}
+		m.commands[name] = cm
+	}
+	cm.calls++
+	// QUIT and SHUTDOWN end with errors for the server, they are not failures.
+	if err != nil && err != lrdb.ErrQuit && err != lrdb.ErrShutdown {
+		cm.errors++
+	}
+	seconds := d.Seconds()
+	cm.sum += seconds
+	i := sort.SearchFloat64s(Buckets, seconds)
+	cm.buckets[i]++
+}
+
+// ServeHTTP writes the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	err := m.Write(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Write writes the metrics in the text exposition format.
+func (m *Metrics) Write(w io.Writer) error {
+	var stats *leveldb.DBStats
+	m.mu.Lock()
+	dbStats, server := m.dbStats, m.server
+	m.mu.Unlock()
+	if dbStats != nil {
+		stats = &leveldb.DBStats{}
+		err := dbStats(stats)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	m.writeCommands(buf)
+	if server != nil {
+		clients := server.Clients()
+		writeMetric(buf, "lrdb_connected_clients", "gauge", "Number of the connected clients.", float64(clients.Len()))
+		writeMetric(buf, "lrdb_max_clients", "gauge", "Max number of the connected clients, 0 means no limit.", float64(clients.Max()))
+		writeMetric(buf, "lrdb_connections_total", "counter", "Number of the connections accepted.", float64(clients.Accepted()))
+		writeMetric(buf, "lrdb_rejected_connections_total", "counter", "Number of the connections rejected for the max number of clients.", float64(clients.Rejected()))
+	}
+	if stats != nil {
+		writeDBStats(buf, stats)
+	}
+	return buf.Flush()
+}
+
+func (m *Metrics) writeCommands(buf *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.commands))
+	for name := range m.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeHeader(buf, "lrdb_commands_total", "counter", "Number of the commands run.")
+	for _, name := range names {
+		writeSample(buf, "lrdb_commands_total", []string{"command", name}, float64(m.commands[name].calls))
+	}
+	writeHeader(buf, "lrdb_command_errors_total", "counter", "Number of the commands that failed.")
+	for _, name := range names {
+		writeSample(buf, "lrdb_command_errors_total", []string{"command", name}, float64(m.commands[name].errors))
+	}
+	writeHeader(buf, "lrdb_command_duration_seconds", "histogram", "Latency of the commands.")
+	for _, name := range names {
+		cm := m.commands[name]
+		var count uint64
+		for i, le := range Buckets {
+			count += cm.buckets[i]
+			writeSample(buf, "lrdb_command_duration_seconds_bucket", []string{"command", name, "le", formatFloat(le)}, float64(count))
+		}
+		count += cm.buckets[len(Buckets)]
+		writeSample(buf, "lrdb_command_duration_seconds_bucket", []string{"command", name, "le", "+Inf"}, float64(count))
+		writeSample(buf, "lrdb_command_duration_seconds_sum", []string{"command", name}, cm.sum)
+		writeSample(buf, "lrdb_command_duration_seconds_count", []string{"command", name}, float64(count))
+	}
+}
+
+func writeDBStats(buf *bufio.Writer, stats *leveldb.DBStats) {
+	writeMetric(buf, "lrdb_leveldb_write_delays_total", "counter", "Number of the writes delayed by compaction.", float64(stats.WriteDelayCount))
+	writeMetric(buf, "lrdb_leveldb_write_delay_seconds_total", "counter", "Time the writes are delayed by compaction.", stats.WriteDelayDuration.Seconds())
+	paused := 0.0
+	if stats.WritePaused {
+		paused = 1
+	}
+	writeMetric(buf, "lrdb_leveldb_write_paused", "gauge", "Whether the writes are paused by compaction.", paused)
+	writeMetric(buf, "lrdb_leveldb_alive_snapshots", "gauge", "Number of the alive snapshots.", float64(stats.AliveSnapshots))
+	writeMetric(buf, "lrdb_leveldb_alive_iterators", "gauge", "Number of the alive iterators.", float64(stats.AliveIterators))
+	writeMetric(buf, "lrdb_leveldb_io_write_bytes_total", "counter", "Bytes written to the storage.", float64(stats.IOWrite))
+	writeMetric(buf, "lrdb_leveldb_io_read_bytes_total", "counter", "Bytes read from the storage.", float64(stats.IORead))
+	writeMetric(buf, "lrdb_leveldb_block_cache_bytes", "gauge", "Size of the block cache.", float64(stats.BlockCacheSize))
+	writeMetric(buf, "lrdb_leveldb_opened_tables", "gauge", "Number of the opened tables.", float64(stats.OpenedTablesCount))
+
+	writeHeader(buf, "lrdb_leveldb_level_size_bytes", "gauge", "Size of the tables of each level.")
+	for level, size := range stats.LevelSizes {
+		writeSample(buf, "lrdb_leveldb_level_size_bytes", []string{"level", strconv.Itoa(level)}, float64(size))
+	}
+	writeHeader(buf, "lrdb_leveldb_level_tables", "gauge", "Number of the tables of each level.")
+	for level, count := range stats.LevelTablesCounts {
+		writeSample(buf, "lrdb_leveldb_level_tables", []string{"level", strconv.Itoa(level)}, float64(count))
+	}
+	writeHeader(buf, "lrdb_leveldb_level_read_bytes_total", "counter", "Bytes read by the compaction of each level.")
+	for level, n := range stats.LevelRead {
+		writeSample(buf, "lrdb_leveldb_level_read_bytes_total", []string{"level", strconv.Itoa(level)}, float64(n))
+	}
+	writeHeader(buf, "lrdb_leveldb_level_write_bytes_total", "counter", "Bytes written by the compaction of each level.")
+	for level, n := range stats.LevelWrite {
+		writeSample(buf, "lrdb_leveldb_level_write_bytes_total", []string{"level", strconv.Itoa(level)}, float64(n))
+	}
+	writeHeader(buf, "lrdb_leveldb_level_compaction_seconds_total", "counter", "Time of the compaction of each level.")
+	for level, d := range stats.LevelDurations {
+		writeSample(buf, "lrdb_leveldb_level_compaction_seconds_total", []string{"level", strconv.Itoa(level)}, d.Seconds())
+	}
+}
+
+func writeMetric(buf *bufio.Writer, name, typ, help string, val float64) {
+	writeHeader(buf, name, typ, help)
+	writeSample(buf, name, nil, val)
+}
+
+func writeHeader(buf *bufio.Writer, name, typ, help string) {
+	buf.WriteString("# HELP " + name + " " + help + "\n")
+	buf.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// writeSample writes a sample, labels are pairs of names and values.
+func writeSample(buf *bufio.Writer, name string, labels []string, val float64) {
+	buf.WriteString(name)
+	if len(labels) != 0 {
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(labels[i])
+			buf.WriteString(`="`)
+			writeLabelValue(buf, labels[i+1])
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(val))
+	buf.WriteByte('\n')
+}
+
+// writeLabelValue escapes the backslashes, the double quotes and the line feeds.
+func writeLabelValue(buf *bufio.Writer, val string) {
+	for i := 0; i != len(val); i++ {
+		switch c := val[i]; c {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,140 @@
+package lrdb
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// The classes of keyspace events.
+const (
+	NotifyKeyspace = 1 << iota // K, published to __keyspace@0__:<key> with the event
+	NotifyKeyevent             // E, published to __keyevent@0__:<event> with the key
+	NotifyGeneric              // g, the commands not specific to a type like del, expire and rename
+	NotifyString               // $, the string commands
+	NotifyList                 // l, the list commands
+	NotifySet                  // s, the set commands
+	NotifyHash                 // h, the hash commands
+	NotifyZSet                 // z, the sorted set commands
+	NotifyExpired              // x, the keys deleted when they expire
+	NotifyEvicted              // e, never happens since keys are not evicted, it's accepted for compatibility
+)
+
+// NotifyAll is all the classes of events except the kinds of channels, 'A' in the flags.
+const NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash | NotifyZSet | NotifyExpired | NotifyEvicted
+
+var ErrNotifyFlags = errors.New("Error invalid keyspace events flags")
+
+var notifyFlags = []struct {
+	flag  byte
+	class int
+}{
+	{'K', NotifyKeyspace},
+	{'E', NotifyKeyevent},
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'l', NotifyList},
+	{'s', NotifySet},
+	{'h', NotifyHash},
+	{'z', NotifyZSet},
+	{'x', NotifyExpired},
+	{'e', NotifyEvicted},
+}
+
+// ParseNotifyFlags parses the classes of events in the format of notify-keyspace-events of Redis,
+// e.g. "KEA" for all events, "Ex" for the keyevent of expired keys, and "" for none.
+func ParseNotifyFlags(flags string) (int, error) {
+	classes := 0
+	for i := 0; i != len(flags); i++ {
+		if flags[i] == 'A' {
+			classes |= NotifyAll
+			continue
+		}
+		found := false
+		for _, f := range notifyFlags {
+			if f.flag == flags[i] {
+				classes |= f.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, ErrNotifyFlags
+		}
+	}
+
+	// Nothing is published without a channel kind and a class of events.
+	if classes&(NotifyKeyspace|NotifyKeyevent) == 0 || classes&NotifyAll == 0 {
+		return 0, nil
+	}
+	return classes, nil
+}
+
+// FormatNotifyFlags formats the classes of events in the format of notify-keyspace-events of Redis.
+func FormatNotifyFlags(classes int) string {
+	buf := []byte{}
+	if classes&NotifyAll == NotifyAll {
+		buf = append(buf, 'A')
+	}
+	for _, f := range notifyFlags {
+		if classes&f.class == 0 {
+			continue
+		}
+		if classes&NotifyAll == NotifyAll && f.class&NotifyAll != 0 {
+			continue
+		}
+		buf = append(buf, f.flag)
+	}
+	return string(buf)
+}
+
+// Notifier receives the events of the modified keys.
+type Notifier interface {
+	Notify(class int, event string, key []byte)
+}
+
+// KeyspaceNotifier publishes the events to the channels of a broker like Redis,
+// they are received by both network clients and in process subscribers of the broker.
+type KeyspaceNotifier struct {
+	broker  *Broker
+	classes int32
+}
+
+// NewKeyspaceNotifier returns a notifier that publishes the events of the classes in flags to broker.
+func NewKeyspaceNotifier(broker *Broker, flags string) (*KeyspaceNotifier, error) {
+	n := &KeyspaceNotifier{
+		broker: broker,
+	}
+	err := n.SetFlags(flags)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// SetFlags changes the classes of events that are published.
+func (n *KeyspaceNotifier) SetFlags(flags string) error {
+	classes, err := ParseNotifyFlags(flags)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&n.classes, int32(classes))
+	return nil
+}
+
+// Flags returns the classes of events that are published.
+func (n *KeyspaceNotifier) Flags() string {
+	return FormatNotifyFlags(int(atomic.LoadInt32(&n.classes)))
+}
+
+func (n *KeyspaceNotifier) Notify(class int, event string, key []byte) {
+	classes := int(atomic.LoadInt32(&n.classes))
+	if classes&class == 0 {
+		return
+	}
+	if classes&NotifyKeyspace != 0 {
+		n.broker.Publish("__keyspace@0__:"+string(key), []byte(event))
+	}
+	if classes&NotifyKeyevent != 0 {
+		n.broker.Publish("__keyevent@0__:"+event, key)
+	}
+}
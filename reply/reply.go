@@ -5,8 +5,9 @@ import (
 )
 
 var (
-	OK   = resp.ReplyStatus("OK")
-	PONG = resp.ReplyStatus("PONG")
-	Zero = resp.ReplyInteger("0")
-	One  = resp.ReplyInteger("1")
+	OK     = resp.ReplyStatus("OK")
+	PONG   = resp.ReplyStatus("PONG")
+	QUEUED = resp.ReplyStatus("QUEUED")
+	Zero   = resp.ReplyInteger("0")
+	One    = resp.ReplyInteger("1")
 )
@@ -0,0 +1,167 @@
+package lrdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/wzshiming/resp"
+)
+
+// The versions of the protocol, a connection speaks RESP2 until it's switched by HELLO.
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// Map is a reply of the pairs of keys and values one after another,
+// it's encoded as a map in RESP3 and as an array in RESP2.
+type Map struct {
+	resp.ReplyMultiBulk
+}
+
+// Set is a reply of unordered unique elements,
+// it's encoded as a set in RESP3 and as an array in RESP2.
+type Set struct {
+	resp.ReplyMultiBulk
+}
+
+// Push is a reply that is sent out of band, like the messages of pub/sub,
+// it's encoded as a push in RESP3 and as an array in RESP2.
+type Push struct {
+	resp.ReplyMultiBulk
+}
+
+// Double is a reply of a floating point number in the text form,
+// it's encoded as a double in RESP3 and as a bulk string in RESP2.
+type Double struct {
+	resp.ReplyBulk
+}
+
+// Encoder encodes replies in the protocol version of the connection.
+// The nil bulk strings and arrays are encoded as the null of RESP3.
+type Encoder struct {
+	writer   *bufio.Writer
+	protocol int
+}
+
+// NewEncoder returns an encoder of RESP2.
+func NewEncoder(writer io.Writer) *Encoder {
+	return &Encoder{
+		writer:   bufio.NewWriter(writer),
+		protocol: RESP2,
+	}
+}
+
+// Protocol returns the version of the protocol.
+func (e *Encoder) Protocol() int {
+	return e.protocol
+}
+
+// SetProtocol sets the version of the protocol.
+func (e *Encoder) SetProtocol(protocol int) {
+	e.protocol = protocol
+}
+
+// Encode encodes r and flushes it, Replies are encoded one after another.
+func (e *Encoder) Encode(r resp.Reply) error {
+	if rs, ok := r.(Replies); ok {
+		for _, r := range rs.ReplyMultiBulk {
+			err := e.encode(r)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err := e.encode(r)
+		if err != nil {
+			return err
+		}
+	}
+	return e.writer.Flush()
+}
+
+func (e *Encoder) encode(r resp.Reply) error {
+	resp3 := e.protocol == RESP3
+	switch t := r.(type) {
+	default:
+		return fmt.Errorf("Error unsupported reply %T", r)
+	case resp.ReplyStatus:
+		return e.line('+', t)
+	case resp.ReplyError:
+		return e.line('-', t)
+	case resp.ReplyInteger:
+		return e.line(':', t)
+	case resp.ReplyBulk:
+		if t == nil {
+			return e.null('$')
+		}
+		return e.bulk(t)
+	case Double:
+		if resp3 {
+			return e.line(',', t.ReplyBulk)
+		}
+		return e.bulk(t.ReplyBulk)
+	case resp.ReplyMultiBulk:
+		if t == nil {
+			return e.null('*')
+		}
+		return e.aggregate('*', len(t), t)
+	case Replies:
+		return e.aggregate('*', len(t.ReplyMultiBulk), t.ReplyMultiBulk)
+	case Map:
+		if resp3 {
+			return e.aggregate('%', len(t.ReplyMultiBulk)/2, t.ReplyMultiBulk)
+		}
+		return e.aggregate('*', len(t.ReplyMultiBulk), t.ReplyMultiBulk)
+	case Set:
+		if resp3 {
+			return e.aggregate('~', len(t.ReplyMultiBulk), t.ReplyMultiBulk)
+		}
+		return e.aggregate('*', len(t.ReplyMultiBulk), t.ReplyMultiBulk)
+	case Push:
+		if resp3 {
+			return e.aggregate('>', len(t.ReplyMultiBulk), t.ReplyMultiBulk)
+		}
+		return e.aggregate('*', len(t.ReplyMultiBulk), t.ReplyMultiBulk)
+	}
+}
+
+func (e *Encoder) line(kind byte, data []byte) error {
+	e.writer.WriteByte(kind)
+	e.writer.Write(data)
+	_, err := e.writer.WriteString("\r\n")
+	return err
+}
+
+func (e *Encoder) bulk(data []byte) error {
+	err := e.line('$', strconv.AppendInt(nil, int64(len(data)), 10))
+	if err != nil {
+		return err
+	}
+	e.writer.Write(data)
+	_, err = e.writer.WriteString("\r\n")
+	return err
+}
+
+func (e *Encoder) null(kind byte) error {
+	if e.protocol == RESP3 {
+		return e.line('_', nil)
+	}
+	return e.line(kind, []byte("-1"))
+}
+
+func (e *Encoder) aggregate(kind byte, size int, items []resp.Reply) error {
+	err := e.line(kind, strconv.AppendInt(nil, int64(size), 10))
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		err := e.encode(item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package lrdb
+
+import (
+	"sync"
+
+	"github.com/wzshiming/resp"
+)
+
+// Session is the state of a connection that lives across commands.
+type Session struct {
+	mu         sync.Mutex
+	values     map[interface{}]interface{}
+	multi      bool
+	queue      []resp.ReplyMultiBulk
+	aborted    bool
+	dirty      bool
+	unwatchs   []func()
+	broker     *Broker
+	subscriber *Subscriber
+	client     *Client
+	addr       string
+	protocol   int
+}
+
+// NewSession returns a session that subscribes with subscriber to the channels of broker,
+// both may be nil if the connection has no pub/sub.
+func NewSession(broker *Broker, subscriber *Subscriber) *Session {
+	return &Session{
+		values:     map[interface{}]interface{}{},
+		broker:     broker,
+		subscriber: subscriber,
+		protocol:   RESP2,
+	}
+}
+
+// Broker returns the broker of the session.
+func (s *Session) Broker() *Broker {
+	return s.broker
+}
+
+// Subscriber returns the subscriber of the session.
+func (s *Session) Subscriber() *Subscriber {
+	return s.subscriber
+}
+
+// Client returns the client of the connection, it's nil if the session is not registered on a server.
+func (s *Session) Client() *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
+
+// SetClient sets the client of the connection.
+func (s *Session) SetClient(c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client = c
+}
+
+// Addr returns the remote address of the client, or the one set by SetAddr if there is no client.
+func (s *Session) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client.Conn().RemoteAddr().String()
+	}
+	return s.addr
+}
+
+// SetAddr sets the remote address of a session without a client, like the one of an HTTP request.
+func (s *Session) SetAddr(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addr = addr
+}
+
+// Protocol returns the version of the protocol that the replies are encoded in.
+func (s *Session) Protocol() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocol
+}
+
+// SetProtocol switches the version of the protocol, it takes effect from the reply of the current command.
+func (s *Session) SetProtocol(protocol int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocol = protocol
+}
+
+// Subscribed returns if the session is in the subscriber mode,
+// which is when any channel or pattern is subscribed.
+func (s *Session) Subscribed() bool {
+	return s.subscriber != nil && s.subscriber.Count() != 0
+}
+
+// Monitoring returns if the session receives the commands run by the server, after MONITOR.
+func (s *Session) Monitoring() bool {
+	return s.subscriber != nil && s.subscriber.Monitoring()
+}
+
+// Value returns the value associated with key, it's used by the engine to keep its own state.
+func (s *Session) Value(key interface{}) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// SetValue associates val with key, a nil val removes it.
+func (s *Session) SetValue(key, val interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if val == nil {
+		delete(s.values, key)
+		return
+	}
+	s.values[key] = val
+}
+
+// Multi marks the start of a transaction block, it returns false if it's already in one.
+func (s *Session) Multi() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.multi {
+		return false
+	}
+	s.multi = true
+	return true
+}
+
+// InMulti returns if the session is in a transaction block.
+func (s *Session) InMulti() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.multi
+}
+
+// Queue queues a command for Exec.
+func (s *Session) Queue(cmd resp.ReplyMultiBulk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, cmd)
+}
+
+// Abort marks the transaction block to be discarded by Exec, since a command could not be queued.
+func (s *Session) Abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aborted = true
+}
+
+// Exec ends the transaction block and returns the queued commands,
+// and whether the transaction block was aborted.
+func (s *Session) Exec() ([]resp.ReplyMultiBulk, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue, aborted := s.queue, s.aborted
+	s.multi = false
+	s.queue = nil
+	s.aborted = false
+	return queue, aborted
+}
+
+// Discard ends the transaction block and drops the queued commands.
+func (s *Session) Discard() {
+	s.Exec()
+}
+
+// Watch registers the function to stop watching keys, it's called by Unwatch.
+func (s *Session) Watch(unwatch func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unwatchs = append(s.unwatchs, unwatch)
+}
+
+// Touch marks that a watched key was modified.
+func (s *Session) Touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = true
+}
+
+// Dirty returns if a watched key was modified since it's watched.
+func (s *Session) Dirty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirty
+}
+
+// Unwatch stops watching all keys.
+func (s *Session) Unwatch() {
+	s.mu.Lock()
+	unwatchs := s.unwatchs
+	s.unwatchs = nil
+	s.dirty = false
+	s.mu.Unlock()
+
+	for _, unwatch := range unwatchs {
+		unwatch()
+	}
+}
+
+// Close releases the session when the connection is gone.
+func (s *Session) Close() {
+	s.Discard()
+	s.Unwatch()
+	if s.broker != nil && s.subscriber != nil {
+		s.broker.Close(s.subscriber)
+	}
+}
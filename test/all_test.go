@@ -1,23 +1,51 @@
 package test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/wzshiming/lrdb"
 	client "github.com/wzshiming/lrdb/client/lrdb"
+	"github.com/wzshiming/lrdb/engine"
 	"github.com/wzshiming/lrdb/engine/leveldb"
+	"github.com/wzshiming/lrdb/metrics"
 	"github.com/wzshiming/lrdb/reply"
 	"github.com/wzshiming/resp"
 )
 
 var testAddress = "127.0.0.1:60101"
+var testBroker *lrdb.Broker
 
 func init() {
 	db, err := leveldb.NewLevelDBWithMemStorage()
@@ -25,8 +53,16 @@ func init() {
 		panic(err)
 	}
 
+	server := lrdb.NewLRDB(db.Cmd())
+	notifier, err := lrdb.NewKeyspaceNotifier(server.Broker(), "KEA")
+	if err != nil {
+		panic(err)
+	}
+	db.SetNotifier(notifier)
+	testBroker = server.Broker()
+
 	go func() {
-		err := lrdb.NewLRDB(db.Cmd()).Listen(testAddress)
+		err := server.Listen(testAddress)
 		if err != nil {
 			panic(err)
 		}
@@ -90,6 +126,2210 @@ func TestRename(t *testing.T) {
 	testCommand(t, "rename", tests)
 }
 
+func TestExpire(t *testing.T) {
+	tests := []command{
+		{[]string{"ttl", "expire_key"}, resp.ReplyInteger("-2"), false},
+		{[]string{"expire", "expire_key", "100"}, reply.Zero, false},
+		{[]string{"set", "expire_key", "world"}, reply.OK, false},
+		{[]string{"ttl", "expire_key"}, resp.ReplyInteger("-1"), false},
+		{[]string{"expire", "expire_key", "100"}, reply.One, false},
+		{[]string{"ttl", "expire_key"}, resp.ReplyInteger("100"), false},
+		{[]string{"incr", "expire_key"}, resp.ReplyError("strconv.ParseInt: parsing \"world\": invalid syntax"), false},
+		{[]string{"rename", "expire_key", "expire_key2"}, reply.OK, false},
+		{[]string{"ttl", "expire_key2"}, resp.ReplyInteger("100"), false},
+		{[]string{"persist", "expire_key2"}, reply.One, false},
+		{[]string{"persist", "expire_key2"}, reply.Zero, false},
+		{[]string{"ttl", "expire_key2"}, resp.ReplyInteger("-1"), false},
+		{[]string{"setex", "expire_key2", "10", "hello"}, reply.OK, false},
+		{[]string{"ttl", "expire_key2"}, resp.ReplyInteger("10"), false},
+		{[]string{"set", "expire_key2", "hello"}, reply.OK, false},
+		{[]string{"ttl", "expire_key2"}, resp.ReplyInteger("-1"), false},
+		{[]string{"expire", "expire_key2", "0"}, reply.One, false},
+		{[]string{"exists", "expire_key2"}, reply.Zero, false},
+		{[]string{"setex", "expire_key2", "0", "hello"}, resp.ReplyError("Error invalid expire time"), false},
+		{[]string{"setex", "expire_key2", "9223372036854775807", "hello"}, resp.ReplyError("Error invalid expire time"), false},
+		{[]string{"set", "expire_key2", "hello"}, reply.OK, false},
+		{[]string{"expire", "expire_key2", "9223372036854775"}, resp.ReplyError("Error invalid expire time"), false},
+		{[]string{"pexpire", "expire_key2", "9223372036854775807"}, resp.ReplyError("Error invalid expire time"), false},
+		{[]string{"ttl", "expire_key2"}, resp.ReplyInteger("-1"), false},
+	}
+	testCommand(t, "expire", tests)
+}
+
+func TestExpireTimeout(t *testing.T) {
+	cli, err := client.NewClient(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	err = cli.Set("timeout_key", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Command("pexpire", "timeout_key", "50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := cli.Keys("timeout_kex", "timeout_key", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"timeout_key"}) {
+		t.Errorf("keys = %v, want [timeout_key]", keys)
+	}
+
+	time.Sleep(time.Second / 10)
+
+	n, err := cli.Exists("timeout_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("exists = %d, want 0", n)
+	}
+	ttl, err := cli.TTL("timeout_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != -2 {
+		t.Errorf("ttl = %d, want -2", ttl)
+	}
+	keys, err = cli.Keys("timeout_kex", "timeout_key", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("keys = %v, want []", keys)
+	}
+}
+
+func TestSetOverwriteRace(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	commands := db.Cmd()
+
+	// The batches of SET race with the transactions of HSET on the same key.
+	var wg sync.WaitGroup
+	for _, cmd := range [][]string{{"set", "race_key", "v"}, {"hset", "race_key", "f", "1"}} {
+		wg.Add(1)
+		go func(cmd []string) {
+			defer wg.Done()
+			session := lrdb.NewSession(nil, nil)
+			for i := 0; i != 200; i++ {
+				args := append([]string{}, cmd...)
+				if cmd[0] == "hset" {
+					args[2] = "f" + strconv.Itoa(i)
+				}
+				commands.Cmd(session, bulks(args...))
+			}
+		}(cmd)
+	}
+	wg.Wait()
+
+	// No element of the hash is left behind by a SET.
+	session := lrdb.NewSession(nil, nil)
+	for _, args := range [][]string{{"set", "race_key", "v"}, {"del", "race_key"}, {"hset", "race_key", "x", "1"}} {
+		_, err = commands.Cmd(session, bulks(args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := commands.Cmd(session, bulks("hlen", "race_key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, reply.One) {
+		t.Errorf("hlen = %v, want 1", got.Format(0))
+	}
+}
+
+func TestMigrateRaw(t *testing.T) {
+	s := storage.NewMemStorage()
+	raw, err := goleveldb.Open(s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The layout before the metadata records, mfoo is also the record of foo in the new one.
+	for k, v := range map[string]string{"foo": "bar", "mfoo": "baz"} {
+		err = raw.Put([]byte(k), []byte(v), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw.Close()
+
+	db, err := leveldb.NewLevelDBWith(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := db.Cmd()
+	session := lrdb.NewSession(nil, nil)
+	for _, tt := range []struct{ key, want string }{{"foo", "bar"}, {"mfoo", "baz"}} {
+		got, err := commands.Cmd(session, bulks("get", tt.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, resp.ReplyBulk(tt.want)) {
+			t.Errorf("get %s = %v, want %s", tt.key, got, tt.want)
+		}
+	}
+	got, err := commands.Cmd(session, bulks("keys", "", "", "10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, bulks("foo", "mfoo")) {
+		t.Errorf("keys = %v, want [foo mfoo]", got)
+	}
+	db.Close()
+
+	// Opening it again doesn't migrate it again.
+	db, err = leveldb.NewLevelDBWith(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = db.Cmd().Cmd(session, bulks("keys", "", "", "10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if !reflect.DeepEqual(got, bulks("foo", "mfoo")) {
+		t.Errorf("keys after reopening = %v, want [foo mfoo]", got)
+	}
+}
+
+func TestHash(t *testing.T) {
+	wrongType := resp.ReplyError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	tests := []command{
+		{[]string{"hlen", "hash_key"}, reply.Zero, false},
+		{[]string{"hget", "hash_key", "a"}, resp.ReplyBulk(nil), false},
+		{[]string{"hset", "hash_key", "a", "1", "b", "2"}, resp.ReplyInteger("2"), false},
+		{[]string{"hset", "hash_key", "b", "3", "c", "4"}, reply.One, false},
+		{[]string{"hlen", "hash_key"}, resp.ReplyInteger("3"), false},
+		{[]string{"hget", "hash_key", "b"}, resp.ReplyBulk("3"), false},
+		{[]string{"hmget", "hash_key", "a", "d"}, resp.ReplyMultiBulk{resp.ReplyBulk("1"), resp.ReplyBulk(nil)}, false},
+		{[]string{"hgetall", "hash_key"}, resp.ReplyMultiBulk{
+			resp.ReplyBulk("a"), resp.ReplyBulk("1"),
+			resp.ReplyBulk("b"), resp.ReplyBulk("3"),
+			resp.ReplyBulk("c"), resp.ReplyBulk("4"),
+		}, false},
+		{[]string{"hscan", "hash_key", "a", "", "1"}, resp.ReplyMultiBulk{
+			resp.ReplyBulk("b"), resp.ReplyBulk("3"),
+		}, false},
+		{[]string{"set", "hash_key2", "a"}, reply.OK, false},
+		{[]string{"hgetall", "hash_key2"}, wrongType, false},
+		{[]string{"get", "hash_key"}, wrongType, false},
+		{[]string{"hdel", "hash_key", "a", "d"}, reply.One, false},
+		{[]string{"rename", "hash_key", "hash_key2"}, reply.OK, false},
+		{[]string{"exists", "hash_key"}, reply.Zero, false},
+		{[]string{"hgetall", "hash_key2"}, resp.ReplyMultiBulk{
+			resp.ReplyBulk("b"), resp.ReplyBulk("3"),
+			resp.ReplyBulk("c"), resp.ReplyBulk("4"),
+		}, false},
+		{[]string{"hdel", "hash_key2", "b", "c"}, resp.ReplyInteger("2"), false},
+		{[]string{"exists", "hash_key2"}, reply.Zero, false},
+		{[]string{"hset", "hash_key2", "a", "1"}, reply.One, false},
+		{[]string{"set", "hash_key2", "a"}, reply.OK, false},
+		{[]string{"hset", "hash_key2", "a", "1"}, wrongType, false},
+		{[]string{"del", "hash_key2"}, reply.One, false},
+		{[]string{"hset", "hash_key2", "b", "1"}, reply.One, false},
+		{[]string{"hgetall", "hash_key2"}, resp.ReplyMultiBulk{
+			resp.ReplyBulk("b"), resp.ReplyBulk("1"),
+		}, false},
+		{[]string{"del", "hash_key2"}, reply.One, false},
+	}
+	testCommand(t, "hash", tests)
+}
+
+func TestList(t *testing.T) {
+	tests := []command{
+		{[]string{"llen", "list_key"}, reply.Zero, false},
+		{[]string{"lpop", "list_key"}, resp.ReplyBulk(nil), false},
+		{[]string{"rpush", "list_key", "c", "d"}, resp.ReplyInteger("2"), false},
+		{[]string{"lpush", "list_key", "b", "a"}, resp.ReplyInteger("4"), false},
+		{[]string{"lrange", "list_key", "0", "-1"}, resp.ReplyMultiBulk{
+			resp.ReplyBulk("a"), resp.ReplyBulk("b"), resp.ReplyBulk("c"), resp.ReplyBulk("d"),
+		}, false},
+		{[]string{"lrange", "list_key", "-3", "1"}, resp.ReplyMultiBulk{resp.ReplyBulk("b")}, false},
+		{[]string{"lrange", "list_key", "3", "1"}, resp.ReplyMultiBulk{}, false},
+		{[]string{"lindex", "list_key", "-1"}, resp.ReplyBulk("d"), false},
+		{[]string{"lindex", "list_key", "4"}, resp.ReplyBulk(nil), false},
+		{[]string{"lpop", "list_key"}, resp.ReplyBulk("a"), false},
+		{[]string{"rpop", "list_key"}, resp.ReplyBulk("d"), false},
+		{[]string{"llen", "list_key"}, resp.ReplyInteger("2"), false},
+		{[]string{"rpush", "list_key", "e", "f", "g"}, resp.ReplyInteger("5"), false},
+		{[]string{"ltrim", "list_key", "1", "-2"}, reply.OK, false},
+		{[]string{"lrange", "list_key", "0", "100"}, resp.ReplyMultiBulk{
+			resp.ReplyBulk("c"), resp.ReplyBulk("e"), resp.ReplyBulk("f"),
+		}, false},
+		{[]string{"get", "list_key"}, resp.ReplyError("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{[]string{"set", "list_key2", "a"}, reply.OK, false},
+		{[]string{"lpush", "list_key2", "a"}, resp.ReplyError("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{[]string{"ltrim", "list_key", "5", "10"}, reply.OK, false},
+		{[]string{"exists", "list_key"}, reply.Zero, false},
+		{[]string{"del", "list_key2"}, reply.One, false},
+	}
+	testCommand(t, "list", tests)
+}
+
+func TestZSet(t *testing.T) {
+	tests := []command{
+		{[]string{"zcard", "zset_key"}, reply.Zero, false},
+		{[]string{"zadd", "zset_key", "3", "c", "1", "a", "-2.5", "b"}, resp.ReplyInteger("3"), false},
+		{[]string{"zadd", "zset_key", "2", "a", "10", "d"}, reply.One, false},
+		{[]string{"zcard", "zset_key"}, resp.ReplyInteger("4"), false},
+		{[]string{"zscore", "zset_key", "b"}, resp.ReplyBulk("-2.5"), false},
+		{[]string{"zscore", "zset_key", "e"}, resp.ReplyBulk(nil), false},
+		{[]string{"zrange", "zset_key", "0", "-1"}, bulks("b", "a", "c", "d"), false},
+		{[]string{"zrange", "zset_key", "1", "2", "withscores"}, bulks("a", "2", "c", "3"), false},
+		{[]string{"zrevrange", "zset_key", "0", "1"}, bulks("d", "c"), false},
+		{[]string{"zrank", "zset_key", "c"}, resp.ReplyInteger("2"), false},
+		{[]string{"zrevrank", "zset_key", "c"}, reply.One, false},
+		{[]string{"zrank", "zset_key", "e"}, resp.ReplyBulk(nil), false},
+		{[]string{"zrangebyscore", "zset_key", "-inf", "+inf"}, bulks("b", "a", "c", "d"), false},
+		{[]string{"zrangebyscore", "zset_key", "(2", "10"}, bulks("c", "d"), false},
+		{[]string{"zrangebyscore", "zset_key", "2", "(10", "withscores", "limit", "1", "5"}, bulks("c", "3"), false},
+		{[]string{"zrevrangebyscore", "zset_key", "+inf", "2"}, bulks("d", "c", "a"), false},
+		{[]string{"zrevrangebyscore", "zset_key", "3", "-inf", "limit", "1", "1"}, bulks("a"), false},
+		{[]string{"zincrby", "zset_key", "-1.5", "d"}, resp.ReplyBulk("8.5"), false},
+		{[]string{"zincrby", "zset_key", "5", "e"}, resp.ReplyBulk("5"), false},
+		{[]string{"zrange", "zset_key", "0", "-1", "withscores"}, bulks("b", "-2.5", "a", "2", "c", "3", "e", "5", "d", "8.5"), false},
+		{[]string{"zadd", "zset_key", "x", "a"}, resp.ReplyError("Error value is not a valid float"), false},
+		{[]string{"zrem", "zset_key", "a", "b", "f"}, resp.ReplyInteger("2"), false},
+		{[]string{"zrangebyscore", "zset_key", "-inf", "+inf"}, bulks("c", "e", "d"), false},
+		{[]string{"rename", "zset_key", "zset_key2"}, reply.OK, false},
+		{[]string{"zrange", "zset_key2", "0", "-1"}, bulks("c", "e", "d"), false},
+		{[]string{"zscore", "zset_key2", "d"}, resp.ReplyBulk("8.5"), false},
+		{[]string{"zrem", "zset_key2", "c", "d", "e"}, resp.ReplyInteger("3"), false},
+		{[]string{"exists", "zset_key2"}, reply.Zero, false},
+	}
+	testCommand(t, "zset", tests)
+}
+
+func TestSet(t *testing.T) {
+	tests := []command{
+		{[]string{"scard", "set_key1"}, reply.Zero, false},
+		{[]string{"sadd", "set_key1", "a", "b", "c", "d", "a"}, resp.ReplyInteger("4"), false},
+		{[]string{"sadd", "set_key2", "c", "d", "e"}, resp.ReplyInteger("3"), false},
+		{[]string{"sadd", "set_key3", "a", "c", "e", "f"}, resp.ReplyInteger("4"), false},
+		{[]string{"scard", "set_key1"}, resp.ReplyInteger("4"), false},
+		{[]string{"sismember", "set_key1", "a"}, reply.One, false},
+		{[]string{"sismember", "set_key1", "e"}, reply.Zero, false},
+		{[]string{"smembers", "set_key2"}, bulks("c", "d", "e"), false},
+		{[]string{"sinter", "set_key1", "set_key2"}, bulks("c", "d"), false},
+		{[]string{"sinter", "set_key1", "set_key2", "set_key3"}, bulks("c"), false},
+		{[]string{"sinter", "set_key1", "set_key0"}, bulks(), false},
+		{[]string{"sunion", "set_key1", "set_key2", "set_key0"}, bulks("a", "b", "c", "d", "e"), false},
+		{[]string{"sdiff", "set_key1", "set_key2"}, bulks("a", "b"), false},
+		{[]string{"sdiff", "set_key1", "set_key2", "set_key3"}, bulks("b"), false},
+		{[]string{"sunionstore", "set_key4", "set_key2", "set_key3"}, resp.ReplyInteger("5"), false},
+		{[]string{"smembers", "set_key4"}, bulks("a", "c", "d", "e", "f"), false},
+		{[]string{"sinterstore", "set_key4", "set_key4", "set_key1"}, resp.ReplyInteger("3"), false},
+		{[]string{"smembers", "set_key4"}, bulks("a", "c", "d"), false},
+		{[]string{"sdiffstore", "set_key4", "set_key4", "set_key1"}, reply.Zero, false},
+		{[]string{"exists", "set_key4"}, reply.Zero, false},
+		{[]string{"srem", "set_key1", "a", "b", "x"}, resp.ReplyInteger("2"), false},
+		{[]string{"smembers", "set_key1"}, bulks("c", "d"), false},
+		{[]string{"sadd", "list_key", "a"}, reply.One, false},
+		{[]string{"lpush", "list_key", "a"}, resp.ReplyError("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{[]string{"del", "set_key1", "set_key2", "set_key3", "list_key"}, resp.ReplyInteger("4"), false},
+	}
+	testCommand(t, "set", tests)
+}
+
+func TestMultiExec(t *testing.T) {
+	tests := []command{
+		{[]string{"exec"}, resp.ReplyError("Error EXEC without MULTI"), false},
+		{[]string{"multi"}, reply.OK, false},
+		{[]string{"multi"}, resp.ReplyError("Error MULTI calls can not be nested"), false},
+		{[]string{"set", "multi_key", "1"}, reply.QUEUED, false},
+		{[]string{"incr", "multi_key"}, reply.QUEUED, false},
+		{[]string{"get", "multi_key"}, reply.QUEUED, false},
+		{[]string{"exec"}, resp.ReplyMultiBulk{reply.OK, resp.ReplyInteger("2"), resp.ReplyBulk("2")}, false},
+		{[]string{"multi"}, reply.OK, false},
+		{[]string{"set", "multi_key", "3"}, reply.QUEUED, false},
+		{[]string{"discard"}, reply.OK, false},
+		{[]string{"get", "multi_key"}, resp.ReplyBulk("2"), false},
+		{[]string{"multi"}, reply.OK, false},
+		{[]string{"set", "multi_key", "a"}, reply.QUEUED, false},
+		{[]string{"incr", "multi_key"}, reply.QUEUED, false},
+		{[]string{"exec"}, resp.ReplyMultiBulk{reply.OK, resp.ReplyError("strconv.ParseInt: parsing \"a\": invalid syntax")}, false},
+		{[]string{"get", "multi_key"}, resp.ReplyBulk("a"), false},
+		{[]string{"multi"}, reply.OK, false},
+		{[]string{"get", "multi_missing"}, reply.QUEUED, false},
+		{[]string{"set", "multi_key", "1"}, reply.QUEUED, false},
+		{[]string{"exec"}, resp.ReplyMultiBulk{resp.ReplyError("leveldb: not found"), reply.OK}, false},
+		{[]string{"get", "multi_key"}, resp.ReplyBulk("1"), false},
+		{[]string{"multi"}, reply.OK, false},
+		{[]string{"nosuch", "multi_key"}, resp.ReplyError("Error Unknown Command 'nosuch'"), false},
+		{[]string{"exec"}, resp.ReplyError("EXECABORT Transaction discarded because of previous errors"), false},
+		{[]string{"del", "multi_key"}, reply.One, false},
+	}
+	testCommand(t, "multi", tests)
+}
+
+func TestCommandInfo(t *testing.T) {
+	tests := []command{
+		{[]string{"command", "info", "get", "nosuch"}, resp.ReplyMultiBulk{
+			resp.ReplyMultiBulk{
+				resp.ReplyBulk("get"), resp.ReplyInteger("2"), resp.ReplyMultiBulk{resp.ReplyStatus("readonly")},
+				resp.ReplyInteger("1"), resp.ReplyInteger("1"), resp.ReplyInteger("1"), resp.ReplyMultiBulk{resp.ReplyStatus("@read")},
+			},
+			resp.ReplyMultiBulk(nil),
+		}, false},
+		{[]string{"command", "docs", "set"}, resp.ReplyMultiBulk{
+			resp.ReplyBulk("set"), bulks("summary", "Set the value of a key"),
+		}, false},
+		{[]string{"command", "getkeys", "mset", "a", "1", "b", "2"}, bulks("a", "b"), false},
+		{[]string{"command", "getkeys", "ping"}, resp.ReplyError("Error The command has no key arguments"), false},
+		{[]string{"command", "getkeys", "get"}, resp.ReplyError("Error Invalid number of arguments specified for command"), false},
+		{[]string{"command", "getkeys", "nosuch", "a"}, resp.ReplyError("Error Invalid command specified"), false},
+		{[]string{"get"}, resp.ReplyError("Error wrong number of arguments"), false},
+		{[]string{"set", "command_key", "1", "2"}, resp.ReplyError("Error wrong number of arguments"), false},
+		{[]string{"multi"}, reply.OK, false},
+		{[]string{"del"}, resp.ReplyError("Error wrong number of arguments"), false},
+		{[]string{"exec"}, resp.ReplyError("EXECABORT Transaction discarded because of previous errors"), false},
+	}
+	testCommand(t, "command", tests)
+
+	cli, err := client.NewClient(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	count, err := cli.CommandCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := cli.Command("command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, ok := all.(resp.ReplyMultiBulk); !ok || len(list) != count {
+		t.Errorf("command = %d commands, count = %d", len(list), count)
+	}
+	keys, err := cli.CommandGetKeys("rename", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("getkeys = %q", keys)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	cli, err := client.NewClient(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	other, err := client.NewClient(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	err = cli.Watch("watch_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Set("watch_key", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.Multi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Command("set", "watch_key", "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := cli.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Errorf("exec = %v, want aborted", r)
+	}
+
+	err = cli.Watch("watch_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.Multi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Command("set", "watch_key", "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = cli.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 {
+		t.Errorf("exec = %v, want 1 reply", r)
+	}
+	v, err := other.Get("watch_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "mine" {
+		t.Errorf("get = %v, want mine", v)
+	}
+	_, err = other.Del("watch_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	sub, err := client.NewClient(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	pub, err := client.NewClient(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	msgs, err := sub.Subscribe("news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := pub.Publish("news", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("publish = %d, want 1", n)
+	}
+	n, err = pub.Publish("other", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("publish = %d, want 0", n)
+	}
+
+	msg := <-msgs
+	if msg.Channel != "news" || msg.Payload != "hello" {
+		t.Errorf("message = %v, want news hello", msg)
+	}
+
+	err = sub.Unsubscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range msgs {
+	}
+
+	msgs, err = sub.PSubscribe("n?ws.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pub.Publish("news.sport", "goal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg = <-msgs
+	if msg.Pattern != "n?ws.*" || msg.Channel != "news.sport" || msg.Payload != "goal" {
+		t.Errorf("message = %v, want n?ws.* news.sport goal", msg)
+	}
+	err = sub.PUnsubscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range msgs {
+	}
+}
+
+func TestPubSubOverflow(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(db.Cmd())
+	server.SetLogger(nil)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = io.WriteString(conn, "SUBSCRIBE slow\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; server.Broker().Publish("slow", nil) == 0; i++ {
+		if i == 100 {
+			t.Fatal("not subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The subscriber doesn't read, the connection is closed once its buffer is full.
+	payload := bytes.Repeat([]byte("x"), 64*1024)
+	overflowed := false
+	for i := 0; i != 10000; i++ {
+		if server.Broker().Publish("slow", payload) == 0 {
+			overflowed = true
+			break
+		}
+	}
+	if !overflowed {
+		t.Fatal("publish never overflowed")
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err = io.Copy(ioutil.Discard, conn)
+	if err != nil {
+		t.Errorf("read = %v, want the connection closed", err)
+	}
+}
+
+func TestKeyspaceNotify(t *testing.T) {
+	cli, err := client.NewClient(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	sub, err := client.NewClient(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	msgs, err := sub.PSubscribe("__keyspace@0__:notify_key*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := lrdb.NewSubscriber(16)
+	testBroker.Subscribe(events, "__keyevent@0__:rename_to")
+	defer testBroker.Close(events)
+
+	err = cli.Set("notify_key", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Incr("notify_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.Rename("notify_key", "notify_key2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cli.Del("notify_key2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"notify_key set",
+		"notify_key incrby",
+		"notify_key rename_from",
+		"notify_key2 rename_to",
+		"notify_key2 del",
+	}
+	for _, w := range want {
+		msg := <-msgs
+		got := strings.TrimPrefix(msg.Channel, "__keyspace@0__:") + " " + msg.Payload
+		if got != w {
+			t.Errorf("keyspace event = %q, want %q", got, w)
+		}
+	}
+
+	msg := <-events.C()
+	if msg.Channel != "__keyevent@0__:rename_to" || string(msg.Payload) != "notify_key2" {
+		t.Errorf("keyevent = %s %s, want __keyevent@0__:rename_to notify_key2", msg.Channel, msg.Payload)
+	}
+
+	err = sub.PUnsubscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range msgs {
+	}
+}
+
+func TestInline(t *testing.T) {
+	conn, err := net.Dial("tcp", testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	tests := []struct {
+		send string
+		want string
+	}{
+		{"set inline_key \"hello world\"\r\n\r\nget inline_key\n", "+OK\r\n$11\r\nhello world\r\n"},
+		{"*2\r\n$3\r\nget\r\n$10\r\ninline_key\r\nstrlen   inline_key\r\n", "$11\r\nhello world\r\n:11\r\n"},
+		{"echo \"a\\x41\\t\\\"\" 'it\\'s'\r\n", "-Error wrong number of arguments\r\n"},
+		{"echo \"a\\x41\\t\\\"\"\r\necho 'it\\'s'\r\n", "$4\r\naA\t\"\r\n$4\r\nit's\r\n"},
+		{"get \"inline_key\r\n", "-" + lrdb.ErrUnbalancedQuotes.Error() + "\r\n"},
+		{"get \"inline\"_key\r\nping\r\n", "-" + lrdb.ErrUnbalancedQuotes.Error() + "\r\n+PONG\r\n"},
+	}
+	for _, tt := range tests {
+		_, err := conn.Write([]byte(tt.send))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(tt.want))
+		_, err = io.ReadFull(reader, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.send, got, tt.want)
+		}
+	}
+}
+
+func TestRESP3(t *testing.T) {
+	conn, err := net.Dial("tcp", testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encoder := resp.NewEncoder(conn)
+	reader := bufio.NewReader(conn)
+
+	read := func(want string) {
+		t.Helper()
+		got := make([]byte, len(want))
+		_, err := io.ReadFull(reader, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	cmd := func(want string, args ...string) {
+		t.Helper()
+		err := encoder.Encode(bulks(args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		read(want)
+	}
+	hello := func(protocol string) {
+		t.Helper()
+		kind := map[string]string{"2": "*12", "3": "%6"}[protocol]
+		cmd(kind+"\r\n$6\r\nserver\r\n$4\r\nlrdb\r\n$5\r\nproto\r\n:"+protocol+"\r\n$2\r\nid\r\n:", "hello", protocol)
+		_, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		read("$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n")
+	}
+
+	cmd(":1\r\n", "hset", "resp3_hash", "f", "v")
+	cmd("*2\r\n$1\r\nf\r\n$1\r\nv\r\n", "hgetall", "resp3_hash")
+	cmd("-NOPROTO unsupported protocol version\r\n", "hello", "4")
+
+	hello("3")
+	cmd("%1\r\n$1\r\nf\r\n$1\r\nv\r\n", "hgetall", "resp3_hash")
+	cmd(":1\r\n", "sadd", "resp3_set", "a")
+	cmd("~1\r\n$1\r\na\r\n", "smembers", "resp3_set")
+	cmd(":1\r\n", "zadd", "resp3_zset", "1.5", "m")
+	cmd(",1.5\r\n", "zscore", "resp3_zset", "m")
+	cmd("*2\r\n$1\r\nm\r\n,1.5\r\n", "zrange", "resp3_zset", "0", "-1", "withscores")
+	cmd("*2\r\n$1\r\nm\r\n,1.5\r\n", "zrangebyscore", "resp3_zset", "-inf", "+inf", "withscores")
+	cmd("_\r\n", "hget", "resp3_hash", "missing")
+
+	// Commands are allowed in the subscriber mode, the messages are pushed after their replies.
+	cmd(">3\r\n$9\r\nsubscribe\r\n$13\r\nresp3_channel\r\n:1\r\n", "subscribe", "resp3_channel")
+	cmd(":1\r\n>3\r\n$7\r\nmessage\r\n$13\r\nresp3_channel\r\n$2\r\nhi\r\n", "publish", "resp3_channel", "hi")
+	cmd(">3\r\n$11\r\nunsubscribe\r\n$13\r\nresp3_channel\r\n:0\r\n", "unsubscribe")
+
+	hello("2")
+	cmd("$-1\r\n", "hget", "resp3_hash", "missing")
+	cmd("*2\r\n$1\r\nm\r\n$3\r\n1.5\r\n", "zrange", "resp3_zset", "0", "-1", "withscores")
+}
+
+func TestHTTP(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(db.Cmd())
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	defer server.Shutdown(context.Background())
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{http.MethodGet, "/keys/http_a", "", http.StatusNotFound, `{"error":"Not Found"}`},
+		{http.MethodPut, "/keys/http_a", "hello world", http.StatusOK, `"OK"`},
+		{http.MethodPut, "/keys/http_b", "2", http.StatusOK, `"OK"`},
+		{http.MethodGet, "/keys/http_a", "", http.StatusOK, `hello world`},
+		{http.MethodPut, "/keys/http~bin", "\xff\x00", http.StatusOK, `"OK"`},
+		{http.MethodGet, "/keys/http~bin", "", http.StatusOK, "\xff\x00"},
+		{http.MethodPost, "/cmd", `["get","http~bin"]`, http.StatusOK, `{"base64":"/wA="}`},
+		{http.MethodPut, "/keys/httr%FF", "v", http.StatusOK, `"OK"`},
+		{http.MethodGet, "/scan?start=httq&end=htts", "", http.StatusOK, `[[{"base64":"aHR0cv8="},"v"]]`},
+		{http.MethodPost, "/cmd", `["publish","http_c","hello"]`, http.StatusOK, `0`},
+		{http.MethodPost, "/cmd", `["subscribe","http_c"]`, http.StatusBadRequest, `{"error":"` + engine.ErrPubSubNotEnable.Error() + `"}`},
+		{http.MethodGet, "/scan?start=http_0&end=http_z", "", http.StatusOK, `{"http_a":"hello world","http_b":"2"}`},
+		{http.MethodGet, "/scan?start=http_0&end=http_z&limit=1", "", http.StatusOK, `{"http_a":"hello world"}`},
+		{http.MethodDelete, "/keys/http_a", "", http.StatusOK, `1`},
+		{http.MethodGet, "/keys/http_a", "", http.StatusNotFound, `{"error":"Not Found"}`},
+		{http.MethodPost, "/cmd", `["hset","http_h","f",1]`, http.StatusOK, `1`},
+		{http.MethodPost, "/cmd", `["hgetall","http_h"]`, http.StatusOK, `{"f":"1"}`},
+		{http.MethodPost, "/cmd", `["hget","http_h","g"]`, http.StatusOK, `null`},
+		{http.MethodPost, "/cmd", `["incr","http_h"]`, http.StatusBadRequest, `{"error":"WRONGTYPE Operation against a key holding the wrong kind of value"}`},
+		{http.MethodPost, "/cmd", `["get",{}]`, http.StatusBadRequest, `{"error":"Error the arguments must be strings or numbers"}`},
+		{http.MethodGet, "/cmd", "", http.StatusMethodNotAllowed, `{"error":"Method Not Allowed"}`},
+		{http.MethodPut, "/keys/http_big", strings.Repeat("x", 64*1024*1024+1), http.StatusBadRequest, `{"error":"http: request body too large"}`},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.code || strings.TrimSpace(string(body)) != tt.want {
+			t.Errorf("%s %s: got %d %s, want %d %s", tt.method, tt.path, res.StatusCode, body, tt.code, tt.want)
+		}
+	}
+}
+
+func TestMemcached(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(db.Cmd())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(context.Background(), listener)
+	go server.ServeMemcached(context.Background(), mcListener, db.Memcached())
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", mcListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	send := func(req string, n int) []string {
+		t.Helper()
+		_, err := io.WriteString(conn, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := []string{}
+		for i := 0; i != n; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+		}
+		return lines
+	}
+	expect := func(req string, want ...string) []string {
+		t.Helper()
+		got := send(req, len(want))
+		for i := range want {
+			// The cas unique is not known in advance.
+			if strings.HasSuffix(want[i], " *") {
+				if !strings.HasPrefix(got[i], strings.TrimSuffix(want[i], "*")) {
+					t.Fatalf("%q: got %q, want %q", req, got, want)
+				}
+				continue
+			}
+			if got[i] != want[i] {
+				t.Fatalf("%q: got %q, want %q", req, got, want)
+			}
+		}
+		return got
+	}
+	casOf := func(line string) string {
+		fields := strings.Fields(line)
+		return fields[len(fields)-1]
+	}
+
+	expect("get mc_a\r\n", "END")
+	expect("set mc_a 5 0 5\r\nhello\r\n", "STORED")
+	expect("add mc_a 0 0 1\r\nx\r\n", "NOT_STORED")
+	expect("replace mc_b 0 0 1\r\nx\r\n", "NOT_STORED")
+	expect("append mc_a 0 0 6\r\n world\r\n", "STORED")
+	expect("prepend mc_a 0 0 1\r\n>\r\n", "STORED")
+	expect("get mc_a mc_b\r\n", "VALUE mc_a 5 12", ">hello world", "END")
+	got := expect("gets mc_a\r\n", "VALUE mc_a 5 12 *", ">hello world", "END")
+	cas := casOf(got[0])
+
+	expect("cas mc_a 7 0 2 "+cas+"\r\nhi\r\n", "STORED")
+	expect("cas mc_a 7 0 2 "+cas+"\r\nho\r\n", "EXISTS")
+	expect("cas mc_b 7 0 2 "+cas+"\r\nho\r\n", "NOT_FOUND")
+	got = expect("gets mc_a\r\n", "VALUE mc_a 7 2 *", "hi", "END")
+	cas = casOf(got[0])
+
+	// The writes of RESP change the version too.
+	cli, err := client.NewClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	r, err := cli.Get("mc_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != "hi" {
+		t.Errorf("get = %q, want %q", r, "hi")
+	}
+	err = cli.Set("mc_a", "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("cas mc_a 0 0 1 "+cas+"\r\nx\r\n", "EXISTS")
+	// The flags are reset by an overwrite of RESP, and kept by the other writes.
+	expect("get mc_a\r\n", "VALUE mc_a 0 2", "10", "END")
+	expect("set mc_d 9 0 1\r\nx\r\n", "STORED")
+	for _, cmd := range [][]string{{"expire", "mc_d", "100"}, {"persist", "mc_d"}, {"rename", "mc_d", "mc_e"}, {"append", "mc_e", "y"}} {
+		_, err = cli.Command(cmd[0], cmd[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect("get mc_e\r\n", "VALUE mc_e 9 2", "xy", "END")
+
+	expect("incr mc_a 5\r\n", "15")
+	expect("decr mc_a 20\r\n", "0")
+	expect("incr mc_b 1\r\n", "NOT_FOUND")
+	expect("set mc_b 0 0 1 noreply\r\nx\r\nincr mc_b 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	expect("touch mc_b 1\r\n", "TOUCHED")
+	expect("touch mc_c 1\r\n", "NOT_FOUND")
+	expect("delete mc_a\r\n", "DELETED")
+	expect("delete mc_a\r\n", "NOT_FOUND")
+	expect("set mc_c 0 -1 1\r\nx\r\nget mc_c\r\n", "STORED", "END")
+	expect("set mc_c 0 0 1\r\nxyz\r\nversion\r\n", "CLIENT_ERROR bad data chunk", "VERSION lrdb")
+	expect("bogus\r\n", "ERROR")
+}
+
+func TestMiddleware(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := db.Cmd()
+	commands.AddCommand("boom", func(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+		panic("boom")
+	})
+
+	var mu sync.Mutex
+	calls := []string{}
+	order := func(tag string) engine.Middleware {
+		return func(next lrdb.CmdFunc) lrdb.CmdFunc {
+			return func(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+				mu.Lock()
+				calls = append(calls, tag+" "+name)
+				mu.Unlock()
+				return next(s, name, args)
+			}
+		}
+	}
+	var timed []string
+	commands.Use(
+		order("outer"),
+		engine.Timing(func(s *lrdb.Session, name string, args []resp.Reply, d time.Duration, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if d < 0 {
+				t.Errorf("duration of %s = %v", name, d)
+			}
+			timed = append(timed, fmt.Sprintf("%s %d %v", name, len(args), err != nil))
+		}),
+		engine.Recovery(nil),
+		order("inner"),
+	)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(commands)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := client.NewConnect(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []command{
+		{[]string{"set", "middleware_key", "1"}, resp.ReplyStatus("OK"), false},
+		{[]string{"boom"}, resp.ReplyError("Error panic in command 'boom': boom"), false},
+		{[]string{"nothing"}, resp.ReplyError("Error Unknown Command 'nothing'"), false},
+		{[]string{"get", "middleware_key"}, resp.ReplyBulk("1"), false},
+	}
+	for _, tt := range tests {
+		r, err := conn.Cmd(bulks(tt.command...))
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%v: error = %v", tt.command, err)
+		}
+		if !resp.Equal(r, tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.command, r.Format(0), tt.want.Format(0))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	wantCalls := []string{
+		"outer set", "inner set",
+		"outer boom", "inner boom",
+		"outer nothing", "inner nothing",
+		"outer get", "inner get",
+	}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("calls = %q, want %q", calls, wantCalls)
+	}
+	wantTimed := []string{"set 2 false", "boom 0 true", "nothing 0 true", "get 1 false"}
+	if !reflect.DeepEqual(timed, wantTimed) {
+		t.Errorf("timed = %q, want %q", timed, wantTimed)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := engine.NewRateLimiter(engine.RateLimitByName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.SetClientLimit(engine.CategoryWrite, engine.RateLimit{Rate: 0.001, Burst: 2})
+	limiter.SetGlobalLimit(engine.CategoryRead, engine.RateLimit{Rate: 0.001, Burst: 3})
+	commands := db.Cmd()
+	commands.SetRateLimiter(limiter)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(commands)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	clients := make([]*client.Client, 3)
+	for i := range clients {
+		clients[i], err = client.NewClient(listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clients[i].Close()
+	}
+	// The first two share the bucket of their name.
+	for _, cli := range clients[:2] {
+		err = cli.ClientSetName("batch")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	limited := engine.ErrRateLimited.Error()
+	steps := []struct {
+		cli     int
+		command []string
+		want    resp.Reply
+	}{
+		{0, []string{"set", "ratelimit_key", "1"}, reply.OK},
+		{1, []string{"set", "ratelimit_key", "2"}, reply.OK},
+		{1, []string{"set", "ratelimit_key", "3"}, resp.ReplyError(limited)},
+		{2, []string{"set", "ratelimit_key", "4"}, reply.OK},
+		{0, []string{"get", "ratelimit_key"}, resp.ReplyBulk("4")},
+		{1, []string{"get", "ratelimit_key"}, resp.ReplyBulk("4")},
+		{2, []string{"get", "ratelimit_key"}, resp.ReplyBulk("4")},
+		{2, []string{"get", "ratelimit_key"}, resp.ReplyError(limited)},
+		{0, []string{"ping"}, reply.PONG},
+	}
+	for _, step := range steps {
+		r, err := clients[step.cli].Command(step.command[0], step.command[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r, step.want) {
+			t.Errorf("client %d %v = %v, want %v", step.cli, step.command, r.Format(0), step.want.Format(0))
+		}
+	}
+
+	info, err := clients[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.RateLimitClientWriteRejected != 1 || info.RateLimitClientReadRejected != 0 ||
+		info.RateLimitGlobalReadRejected != 1 || info.RateLimitClients != 2 {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestRateLimitByAddr(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := engine.NewRateLimiter(engine.RateLimitByAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.SetClientLimit(engine.CategoryWrite, engine.RateLimit{Rate: 0.001, Burst: 1})
+	commands := db.Cmd()
+	commands.SetRateLimiter(limiter)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(commands)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	// The connections from the same host share the bucket, though their ports differ.
+	want := []resp.Reply{reply.OK, resp.ReplyError(engine.ErrRateLimited.Error())}
+	for i, want := range want {
+		cli, err := client.NewClient(listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cli.Close()
+		r, err := cli.Command("set", "ratelimit_addr", "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r, want) {
+			t.Errorf("client %d set = %v, want %v", i, r.Format(0), want.Format(0))
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := db.Cmd()
+	metric := metrics.NewMetrics()
+	metric.Instrument(commands)
+	metric.SetDBStats(db.DBStats)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(commands)
+	metric.SetServer(server)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	cli, err := client.NewClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	err = cli.Set("metrics_key", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range [][]string{{"get", "metrics_none"}, {"get", "metrics_key"}, {"nosuch"}, {"set", "metrics_key"}} {
+		_, err = cli.Command(cmd[0], cmd[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(metric)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("content type = %q", ct)
+	}
+	lines := map[string]bool{}
+	for _, line := range strings.Split(string(body), "\n") {
+		lines[line] = true
+	}
+	for _, want := range []string{
+		"# TYPE lrdb_commands_total counter",
+		`lrdb_commands_total{command="set"} 2`,
+		`lrdb_commands_total{command="get"} 2`,
+		`lrdb_commands_total{command="unknown"} 1`,
+		`lrdb_command_errors_total{command="get"} 1`,
+		`lrdb_command_errors_total{command="set"} 1`,
+		"# TYPE lrdb_command_duration_seconds histogram",
+		`lrdb_command_duration_seconds_bucket{command="get",le="+Inf"} 2`,
+		`lrdb_command_duration_seconds_count{command="get"} 2`,
+		"lrdb_connected_clients 1",
+		"lrdb_connections_total 1",
+		"# TYPE lrdb_leveldb_level_size_bytes gauge",
+		"lrdb_leveldb_write_paused 0",
+	} {
+		if !lines[want] {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := db.Cmd()
+	commands.AddCommand("slow", func(s *lrdb.Session, name string, args []resp.Reply) (resp.Reply, error) {
+		time.Sleep(60 * time.Millisecond)
+		return reply.OK, nil
+	})
+	commands.SlowLog().SetThreshold(50 * time.Millisecond)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(commands)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	cli, err := client.NewClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	err = cli.ClientSetName("slow-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{strings.Repeat("x", 200)}
+	for i := 0; i != 39; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	_, err = cli.Command("slow", args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.Set("slowlog_key", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := cli.SlowLogGet(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("slowlog get = %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.ID != 1 || entry.Duration < 50*time.Millisecond || entry.Name != "slow-client" ||
+		entry.Addr == "" || time.Since(entry.Time) > time.Minute {
+		t.Errorf("entry = %+v", entry)
+	}
+	if len(entry.Args) != 32 || entry.Args[0] != "slow" ||
+		entry.Args[1] != strings.Repeat("x", 128)+"... (72 more bytes)" ||
+		entry.Args[31] != "... (10 more arguments)" {
+		t.Errorf("args = %q", entry.Args)
+	}
+
+	err = cli.SlowLogReset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands.SlowLog().SetMaxLen(1)
+	for i := 0; i != 2; i++ {
+		_, err = cli.Command("slow")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := cli.SlowLogLen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = cli.SlowLogGet(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(entries) != 1 || entries[0].ID != 3 {
+		t.Errorf("slowlog len = %d, entries = %+v", n, entries)
+	}
+
+	commands.SlowLog().SetThreshold(0)
+	_, err = cli.Command("config", "set", "requirepass", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = cli.SlowLogGet(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Args, []string{"config", "(redacted)"}) {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(db.Cmd())
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+	address := listener.Addr().String()
+
+	monitor := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		_, err = io.WriteString(conn, "MONITOR\r\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != "+OK\r\n" {
+			t.Fatalf("monitor = %q", line)
+		}
+		return conn, reader
+	}
+	conn, reader := monitor()
+	defer conn.Close()
+	// A monitor that never reads must not block the commands.
+	stalled, _ := monitor()
+	defer stalled.Close()
+
+	cli, err := client.NewClient(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	err = cli.Set("monitor_key", "a\"b\n\x01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.Command("auth", "user", "secret")
+
+	prefix := `^\+\d+\.\d{6} \[0 127\.0\.0\.1:\d+\] `
+	for _, want := range []string{
+		prefix + `"monitor"\r\n$`,
+		prefix + `"ping"\r\n$`,
+		prefix + `"set" "monitor_key" "a\\"b\\n\\x01"\r\n$`,
+		prefix + `"auth" \(redacted\)\r\n$`,
+	} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(want).MatchString(line) {
+			t.Errorf("monitor line = %q, want %q", line, want)
+		}
+	}
+
+	// The commands are written as raw RESP, pipelined on a connection of their own.
+	writer, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	value := strings.Repeat("x", 16*1024)
+	set := fmt.Sprintf("*3\r\n$3\r\nSET\r\n$11\r\nmonitor_big\r\n$%d\r\n%s\r\n", len(value), value)
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i != 1000; i++ {
+			_, err := io.WriteString(writer, set)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	replies := bufio.NewReader(writer)
+	writer.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for i := 0; i != 1000; i++ {
+		line, err := replies.ReadString('\n')
+		if err != nil {
+			t.Fatalf("commands are blocked by the monitor: %v", err)
+		}
+		if line != "+OK\r\n" {
+			t.Fatalf("set = %q", line)
+		}
+	}
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMonitorACL(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := db.Cmd()
+	commands.SetACL(engine.NewACL("secret"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(commands)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "AUTH secret\r\nMONITOR\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"+OK\r\n", "+OK\r\n"} {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Fatalf("monitor = %q", line)
+		}
+	}
+
+	cli, err := client.NewClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	// Refused by ACL, it's not fed to the monitors.
+	cli.Command("set", "monitor_acl", "value")
+	cli.Command("auth", "secret")
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `^\+\d+\.\d{6} \[0 127\.0\.0\.1:\d+\] "auth" \(redacted\)\r\n$`
+	if !regexp.MustCompile(want).MatchString(line) {
+		t.Errorf("monitor line = %q, want %q", line, want)
+	}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLogger(t *testing.T) {
+	text := bytes.NewBuffer(nil)
+	logger, err := lrdb.NewLevelLogger(text, lrdb.LevelInfo, lrdb.LogText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Log(lrdb.LevelDebug, "Join", lrdb.F("addr", "127.0.0.1:1"))
+	logger.Log(lrdb.LevelInfo, "Quit", lrdb.F("addr", "127.0.0.1:1"), lrdb.F("reason", "idle timeout"))
+	if !regexp.MustCompile(`^\[LRDB\] \S+ \S+ INFO Quit addr=127.0.0.1:1 reason="idle timeout"\n$`).MatchString(text.String()) {
+		t.Errorf("text log = %q", text.String())
+	}
+
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &lockedBuffer{}
+	logger, err = lrdb.NewLevelLogger(output, lrdb.LevelDebug, lrdb.LogJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := db.Cmd()
+	commands.Use(engine.Logging(logger, lrdb.LevelDebug))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(commands)
+	server.SetLogger(logger)
+	go server.Serve(context.Background(), listener)
+
+	cli, err := client.NewClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.Set("logger_key", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.Command("auth", "secret")
+	cli.Close()
+	err = server.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string][]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		entry := map[string]interface{}{}
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if entry["time"] == nil || entry["level"] == nil {
+			t.Errorf("entry = %v", entry)
+		}
+		msg, _ := entry["msg"].(string)
+		entries[msg] = append(entries[msg], entry)
+	}
+	if len(entries["Join"]) != 1 || entries["Join"][0]["addr"] == "" || entries["Join"][0]["level"] != "debug" {
+		t.Errorf("join = %v", entries["Join"])
+	}
+	if len(entries["Command"]) != 4 || entries["Command"][3]["error"] != nil {
+		t.Fatalf("commands = %v", entries["Command"])
+	}
+	set, auth := entries["Command"][1], entries["Command"][2]
+	if set["command"] != "set" || set["args"] != `"logger_key" "1"` || set["duration"] == nil || set["addr"] == nil {
+		t.Errorf("set = %v", set)
+	}
+	if auth["args"] != "(redacted)" || auth["error"] == nil || auth["level"] != "warn" {
+		t.Errorf("auth = %v", auth)
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lrdb-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lrdb.log")
+
+	file, err := lrdb.OpenLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	io.WriteString(file, "first\n")
+	// Rotated like logrotate, the new logs go to a new file after reopening.
+	err = os.Rename(path, path+".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Reopen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(file, "second\n")
+
+	for name, want := range map[string]string{path + ".1": "first\n", path: "second\n"} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lrdb-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "lrdb.conf")
+	err = ioutil.WriteFile(file, []byte("# Test config\nmaxclients 100\ntimeout 1m\nrequirepass \"p w\"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("lrdb", flag.ContinueOnError)
+	maxClients := flags.Int("maxclients", 10000, "")
+	idleTimeout := flags.Duration("timeout", 0, "")
+	requirePass := flags.String("requirepass", "", "")
+	port := flags.String("p", ":10008", "")
+	flags.String("config", file, "")
+	err = flags.Parse([]string{"-timeout", "5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("LRDB_TEST_MAXCLIENTS", "200")
+	defer os.Unsetenv("LRDB_TEST_MAXCLIENTS")
+
+	config := lrdb.NewConfig(flags, file, "LRDB_TEST_")
+	config.Hide("config")
+	err = config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The command line overrides the environment, which overrides the file.
+	if *maxClients != 200 || *idleTimeout != 5*time.Second || *requirePass != "p w" || *port != ":10008" {
+		t.Fatalf("loaded %d %s %q %q", *maxClients, *idleTimeout, *requirePass, *port)
+	}
+
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := db.Cmd()
+	commands.SetConfig(config)
+	server := lrdb.NewLRDB(commands)
+	config.Hook("maxclients", func() error {
+		if *maxClients < 0 {
+			return fmt.Errorf("should not be negative")
+		}
+		server.SetMaxClients(*maxClients)
+		return nil
+	})
+	err = config.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	cli, err := client.NewClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	params, err := cli.ConfigGet("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"maxclients": "200", "timeout": "5s", "requirepass": "p w", "p": ":10008"}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("config get * = %v, want %v", params, want)
+	}
+
+	err = cli.ConfigSet("maxclients", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Clients().Max() != 1 {
+		t.Errorf("max clients = %d, want 1", server.Clients().Max())
+	}
+	for _, args := range [][]string{
+		{"maxclients", "-1"},
+		{"maxclients", "x"},
+		{"timeout", "1s"},
+		{"config", "x"},
+		{"unknown", "x"},
+		{"maxclients", "2", "timeout", "1s"},
+	} {
+		got, err := cli.Command("config", append([]string{"set"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got.(resp.ReplyError); !ok {
+			t.Errorf("config set %v = %v, want an error", args, got)
+		}
+	}
+	params, err = cli.ConfigGet("max*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["maxclients"] != "1" || server.Clients().Max() != 1 {
+		t.Errorf("maxclients = %v, %d, want 1 after the failed sets", params, server.Clients().Max())
+	}
+
+	err = cli.ConfigRewrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFile := "# Test config\nmaxclients 1\ntimeout 5s\nrequirepass \"p w\"\n"
+	if string(data) != wantFile {
+		t.Errorf("rewritten = %q, want %q", data, wantFile)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	for _, byCommand := range []bool{false, true} {
+		db, err := leveldb.NewLevelDBWithMemStorage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := lrdb.NewLRDB(db.Cmd())
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Serve(context.Background(), listener)
+		}()
+
+		cli, err := client.NewClient(listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cli.Set("shutdown_key", "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if byCommand {
+			_, err = cli.Command("shutdown")
+			if err == nil {
+				t.Error("shutdown replied")
+			}
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			err = server.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = cli.Ping()
+			if err == nil {
+				t.Error("ping after shutdown")
+			}
+		}
+
+		select {
+		case err = <-served:
+			if err != lrdb.ErrServerClosed {
+				t.Errorf("serve = %v, want %v", err, lrdb.ErrServerClosed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("serve is not returned")
+		}
+
+		_, err = net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			t.Error("dial after shutdown")
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lrdb-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	clientCert, clientKey := writeCert(t, dir, "client", ca, caKey)
+
+	loader, err := lrdb.NewTLSLoader(lrdb.TLSOptions{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server-key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", loader.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(db.Cmd())
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	config := &tls.Config{
+		RootCAs:    roots,
+		ServerName: "127.0.0.1",
+	}
+
+	_, err = client.NewClient(listener.Addr().String(), client.WithTLS(config))
+	if err == nil {
+		t.Error("connected without a client certificate")
+	}
+
+	config.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{clientCert.Raw},
+		PrivateKey:  clientKey,
+	}}
+	cli, err := client.NewClient(listener.Addr().String(), client.WithTLS(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	err = cli.Set("tls_key", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new certificate is used by the new connections after reloading.
+	newServer, _ := writeCert(t, dir, "server", ca, caKey)
+	err = loader.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !conn.ConnectionState().PeerCertificates[0].Equal(newServer) {
+		t.Error("certificate is not reloaded")
+	}
+
+	// The reloaded certificate is also served by GetCertificate alone.
+	certConfig := loader.Config()
+	certConfig.GetConfigForClient = nil
+	certListener, err := tls.Listen("tcp", "127.0.0.1:0", certConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer certListener.Close()
+	go func() {
+		conn, err := certListener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+	certConn, err := tls.Dial("tcp", certListener.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer certConn.Close()
+	if !certConn.ConnectionState().PeerCertificates[0].Equal(newServer) {
+		t.Error("certificate is not served by GetCertificate")
+	}
+}
+
+// writeCert writes a certificate for 127.0.0.1 signed by parent, or a CA if parent is nil,
+// to name.pem and name-key.pem in dir.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(rand.Int63()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lrdb-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lrdb.sock")
+
+	// A socket file left behind by a previous process.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(db.Cmd())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenUnix(path, 0600)
+	}()
+
+	var cli *client.Client
+	for i := 0; ; i++ {
+		cli, err = client.NewClient("unix://" + path)
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer cli.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("permissions = %o, want %o", perm, 0600)
+	}
+
+	err = cli.Set("unix_key", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := cli.Get("unix_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != "1" {
+		t.Errorf("get = %q, want %q", r, "1")
+	}
+
+	err = server.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-served
+	if err != lrdb.ErrServerClosed {
+		t.Errorf("serve = %v, want %v", err, lrdb.ErrServerClosed)
+	}
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Error("socket file is not removed")
+	}
+}
+
+func TestClients(t *testing.T) {
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(db.Cmd())
+	server.SetMaxClients(2)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+	address := listener.Addr().String()
+
+	first, err := client.NewClient(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	err = first.ClientSetName("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := first.ClientGetName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "first" {
+		t.Errorf("name = %q, want %q", name, "first")
+	}
+	err = first.ClientSetName("a b")
+	if err == nil {
+		t.Error("name with spaces is set")
+	}
+
+	second, err := client.NewClient(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := second.ClientID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := client.NewConnect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := third.Cmd(bulks("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, resp.ReplyError(lrdb.ErrMaxClients.Error())) {
+		t.Errorf("over max clients = %v", r)
+	}
+
+	list, err := first.ClientList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(list), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("client list = %q", list)
+	}
+	if !strings.Contains(lines[0], " name=first ") || !strings.Contains(lines[0], " cmd=client ") {
+		t.Errorf("client list = %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "id="+strconv.FormatInt(id, 10)+" ") {
+		t.Errorf("client list = %q", lines[1])
+	}
+
+	r, err = first.Command("client", "kill", "id", strconv.FormatInt(id, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, resp.ReplyInteger("1")) {
+		t.Errorf("client kill = %v", r)
+	}
+	_, err = second.Ping()
+	if err == nil {
+		t.Error("ping after killed")
+	}
+
+	server.SetIdleTimeout(100 * time.Millisecond)
+	var idle *client.Client
+	for i := 0; ; i++ {
+		idle, err = client.NewClient(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, _ := idle.Ping()
+		if ok {
+			break
+		}
+		// The killed client may not be unregistered yet.
+		if i == 100 {
+			t.Fatal("max clients reached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+	_, err = idle.Ping()
+	if err == nil {
+		t.Error("ping after idle timeout")
+	}
+}
+
+func TestACL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lrdb-acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "users.acl")
+	err = ioutil.WriteFile(file, []byte("# users\nuser writer on >wpass ~cache:* +@read +@write\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := leveldb.NewLevelDBWithMemStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := db.Cmd()
+	acl := engine.NewACL("secret")
+	err = acl.LoadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands.SetACL(acl)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := lrdb.NewLRDB(commands)
+	go server.Serve(context.Background(), listener)
+	defer server.Shutdown(context.Background())
+
+	cli, err := client.NewConnect(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noPerm := resp.ReplyError("NOPERM this user has no permissions to run the 'set' command")
+	noPermKeys := resp.ReplyError("NOPERM this user has no permissions to access one of the keys used as arguments")
+	noPermChannels := resp.ReplyError("NOPERM this user has no permissions to access one of the channels used as arguments")
+	tests := []command{
+		{[]string{"get", "cache:a"}, resp.ReplyError("NOAUTH Authentication required."), false},
+		{[]string{"auth", "wrong"}, resp.ReplyError("WRONGPASS invalid username-password pair or user is disabled."), false},
+		{[]string{"auth", "secret"}, reply.OK, false},
+		{[]string{"acl", "whoami"}, resp.ReplyBulk("default"), false},
+		{[]string{"acl", "setuser", "reader", "on", ">rpass", "~cache:*", "+@read"}, reply.OK, false},
+		{[]string{"acl", "setuser", "reader", "+@bad"}, resp.ReplyError("Error invalid ACL rule '+@bad'"), false},
+		{[]string{"acl", "setuser", "reader", "~cache:?"}, resp.ReplyError("Error invalid ACL rule '~cache:?'"), false},
+		{[]string{"set", "cache:a", "1"}, reply.OK, false},
+		{[]string{"auth", "reader", "rpass"}, reply.OK, false},
+		{[]string{"acl", "whoami"}, resp.ReplyBulk("reader"), false},
+		{[]string{"get", "cache:a"}, resp.ReplyBulk("1"), false},
+		{[]string{"get", "other"}, noPermKeys, false},
+		{[]string{"set", "cache:a", "2"}, noPerm, false},
+		{[]string{"keys", "cache:0", "cache:~", "10"}, bulks("cache:a"), false},
+		{[]string{"keys", "", "", "10"}, noPermKeys, false},
+		{[]string{"keys", "cache:0", "", "10"}, noPermKeys, false},
+		{[]string{"scan", "a:cache:", "z:cache:", "10"}, noPermKeys, false},
+		{[]string{"rkeys", "cache:0", "cachf", "10"}, noPermKeys, false},
+		{[]string{"rscan", "cache:0", "cache:~", "10"}, resp.ReplyMultiBulk{resp.ReplyBulk("cache:a"), resp.ReplyBulk("1")}, false},
+		{[]string{"psubscribe", "__keyspace@0__:*"}, noPermChannels, false},
+		{[]string{"psubscribe", "*"}, noPermChannels, false},
+		{[]string{"subscribe", "__keyevent@0__:set"}, noPermChannels, false},
+		{[]string{"subscribe", "__keyspace@0__:other"}, noPermChannels, false},
+		{[]string{"acl", "list"}, resp.ReplyError("NOPERM this user has no permissions to run the 'acl' command"), false},
+		{[]string{"auth", "writer", "wpass"}, reply.OK, false},
+		{[]string{"set", "cache:a", "2"}, reply.OK, false},
+		{[]string{"mset", "cache:b", "1", "other", "1"}, noPermKeys, false},
+		{[]string{"publish", "__keyspace@0__:other", "del"}, noPermChannels, false},
+		{[]string{"publish", "__keyevent@0__:del", "cache:a"}, noPermChannels, false},
+		{[]string{"publish", "__keyspace@0__:cache:a", "del"}, reply.Zero, false},
+		{[]string{"publish", "news", "hello"}, reply.Zero, false},
+		{[]string{"auth", "secret"}, reply.OK, false},
+		{[]string{"acl", "deluser", "reader", "nobody"}, reply.One, false},
+		{[]string{"acl", "deluser", "default"}, resp.ReplyError("Error the 'default' user cannot be removed"), false},
+		{[]string{"acl", "list"}, bulks(
+			"user default on #2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b ~* +@all",
+			"user writer on #"+fmt.Sprintf("%x", sha256.Sum256([]byte("wpass")))+" ~cache:* +@read +@write",
+		), false},
+	}
+	for _, tt := range tests {
+		cmd := strings.Join(tt.command, " ")
+		req, _ := resp.ConvertTo(tt.command)
+		got, err := cli.Cmd(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("'%v' = %v, want %v", cmd, got.Format(0), tt.want.Format(0))
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*", "", true},
+		{"news.*", "news.sport", true},
+		{"news.*", "new.sport", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*:key", "__keyspace@0__:key", true},
+	}
+	for _, tt := range tests {
+		if got := lrdb.Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func bulks(s ...string) resp.ReplyMultiBulk {
+	r := resp.ReplyMultiBulk{}
+	for _, v := range s {
+		r = append(r, resp.ReplyBulk(v))
+	}
+	return r
+}
+
 func testCommand(t *testing.T, name string, command []command) {
 	cli, err := client.NewClient(testAddress)
 	if err != nil {
@@ -0,0 +1,113 @@
+package lrdb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"sync/atomic"
+)
+
+var (
+	ErrTLSVersion  = errors.New("Error unsupported TLS version")
+	ErrTLSClientCA = errors.New("Error no certificate found in the client CA file")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version like "1.2".
+func ParseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, ErrTLSVersion
+	}
+	return v, nil
+}
+
+// TLSOptions are the files and settings of a TLS listener.
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // If set, clients must present a certificate signed by it
+	MinVersion   uint16 // Defaults to TLS 1.2
+}
+
+// TLSLoader loads the TLS configuration from files,
+// and reloads them on Reload without affecting the established connections.
+type TLSLoader struct {
+	options TLSOptions
+	config  atomic.Value
+}
+
+func NewTLSLoader(options TLSOptions) (*TLSLoader, error) {
+	if options.MinVersion == 0 {
+		options.MinVersion = tls.VersionTLS12
+	}
+	l := &TLSLoader{
+		options: options,
+	}
+	err := l.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload reads the files again, the new connections are handshaked with them.
+// The previous configuration is kept if any of them is invalid.
+func (l *TLSLoader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(l.options.CertFile, l.options.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   l.options.MinVersion,
+	}
+	if l.options.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(l.options.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return ErrTLSClientCA
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	l.config.Store(config)
+	return nil
+}
+
+// Config returns the configuration for a listener, which uses the latest loaded one for each handshake.
+// GetCertificate also serves the latest certificate, for the users that replace GetConfigForClient.
+func (l *TLSLoader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:     l.options.MinVersion,
+		GetCertificate: l.getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return l.config.Load().(*tls.Config), nil
+		},
+	}
+}
+
+func (l *TLSLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &l.config.Load().(*tls.Config).Certificates[0], nil
+}
+
+// ListenTLS is like Listen, but the connections are TLS with config.
+func (db *LRDB) ListenTLS(address string, config *tls.Config) error {
+	listen, err := tls.Listen("tcp", address, config)
+	if err != nil {
+		return err
+	}
+	db.logger.Log(LevelInfo, "Listen", F("addr", address), F("protocol", "tls"))
+	return db.Serve(context.Background(), listen)
+}
@@ -0,0 +1,29 @@
+package lrdb
+
+import (
+	"context"
+	"net"
+	"os"
+)
+
+// ListenUnix is like Listen, but on the Unix socket at path with the file permissions perm.
+// A socket file left behind by a previous process is removed first.
+func (db *LRDB) ListenUnix(path string, perm os.FileMode) error {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	listen, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(path, perm)
+	if err != nil {
+		listen.Close()
+		return err
+	}
+	db.logger.Log(LevelInfo, "Listen", F("addr", "unix://"+path))
+	return db.Serve(context.Background(), listen)
+}